@@ -2,18 +2,20 @@ package analytics
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var _ ContextClient = (*client)(nil)
+
 // Version of the client.
 const Version = "4.2.1"
 
@@ -40,14 +42,42 @@ type Client interface {
 	Enqueue(Message) error
 }
 
+// This interface extends `Client` with a method honoring a context.Context,
+// the clients returned by the constructors of the package implement it, see
+// `EnqueueContext`.
+type ContextClient interface {
+	Client
+
+	// Same as Enqueue but the user identifiers and analytics context carried
+	// by the context.Context (see `WithUser` and `WithAnalyticsContext`) are
+	// set on the message when it doesn't have its own.
+	// If the queue is full, the method waits for room to be available or for
+	// the context to be canceled, in which case the context error is returned
+	// and the message is discarded.
+	EnqueueContext(context.Context, Message) error
+}
+
+// Queues a message on c with the user identifiers and analytics context carried
+// by ctx, using the `EnqueueContext` method of c when it implements
+// `ContextClient`. Other clients get the message with the values of ctx set on
+// it, but they don't stop waiting for room in their queue when ctx is canceled.
+func EnqueueContext(ctx context.Context, c Client, msg Message) error {
+	if cc, ok := c.(ContextClient); ok {
+		return cc.EnqueueContext(ctx, msg)
+	}
+	return c.Enqueue(propagate(ctx, dereferenceMessage(msg)))
+}
+
 type client struct {
 	Config
 	key string
 
-	// This channel is where the `Enqueue` method writes messages so they can be
-	// picked up and pushed by the backend goroutine taking care of applying the
-	// batching rules.
-	msgs chan Message
+	// The shards where the `Enqueue` method writes messages, each is served by
+	// its own backend goroutine.
+	shards []*shard
+
+	// Used to spread the messages that have no user across shards.
+	next atomic.Uint32
 
 	// These two channels are used to synchronize the client shutting down when
 	// `Close` is called.
@@ -60,6 +90,41 @@ type client struct {
 	// This HTTP client is used to send requests to the backend, it uses the
 	// HTTP transport provided in the configuration.
 	http http.Client
+
+	// Users for which messages are dropped by `Enqueue`, the list is filled
+	// by regulation clients created from this client.
+	suppressions *suppressionList
+
+	// Ids of the messages queued recently, nil unless deduplication was
+	// enabled in the configuration.
+	dedup *dedupCache
+
+	// Set to CompressionGzip once the server rejected the configured
+	// compression, zero while the configured compression is used.
+	negotiated atomic.Int32
+
+	// The circuit breaker around the data plane, nil unless it was enabled in
+	// the configuration.
+	breaker *breaker
+
+	// The state of the adaptive batching, nil unless it was enabled in the
+	// configuration.
+	batcher *batcher
+
+	// Sends the batches to the data plane with the protocol set in the
+	// configuration.
+	uploader uploader
+
+	// The transport created for the client when the configuration didn't
+	// have one, its idle connections are closed with the client.
+	transport *http.Transport
+}
+
+// Messages are written to the channels of clients along with the time they
+// were queued at.
+type queuedMessage struct {
+	msg Message
+	at  time.Time
 }
 
 type batchRequest struct {
@@ -96,10 +161,27 @@ func NewWithConfig(writeKey string, config Config) (cli Client, err error) {
 	c := &client{
 		Config:   makeConfig(config),
 		key:      writeKey,
-		msgs:     make(chan Message, 100),
 		quit:     make(chan struct{}),
 		shutdown: make(chan struct{}),
-		http:     makeHttpClient(config.Transport),
+
+		suppressions: &suppressionList{},
+	}
+
+	c.http = makeHttpClient(c.Transport, c.Timeout)
+	c.transport = ownedTransport(config, c.Config)
+	c.shards = newShards(c.Shards)
+	c.uploader = newUploader(c)
+
+	if c.DedupWindow != 0 {
+		c.dedup = newDedupCache(c.DedupWindow, c.DedupCacheSize)
+	}
+
+	if c.CircuitBreaker != nil {
+		c.breaker = newBreaker(c.CircuitBreaker, spillNameOf(c.key, c.Endpoint), c.circuitStateChanged)
+	}
+
+	if c.Batching != nil {
+		c.batcher = newBatcher(c.Batching, c.Interval, c.maxBatchBytes())
 	}
 	c.totalNodes = 1
 
@@ -109,16 +191,6 @@ func NewWithConfig(writeKey string, config Config) (cli Client, err error) {
 	return
 }
 
-func makeHttpClient(transport http.RoundTripper) http.Client {
-	httpClient := http.Client{
-		Transport: transport,
-	}
-	if supportsTimeout(transport) {
-		httpClient.Timeout = 10 * time.Second
-	}
-	return httpClient
-}
-
 func makeContext(context *Context) *Context {
 	if context == nil {
 		context = &Context{}
@@ -132,6 +204,18 @@ func makeContext(context *Context) *Context {
 	return context
 }
 
+// Same as makeContext but also applies the enrichers of the client. The context
+// is copied first so the application can reuse it across messages, the fields
+// set by the client and its enrichers would otherwise leak from one message to
+// the next, and race with the marshaling of the messages already queued.
+func (c *client) makeContext(context *Context) *Context {
+	context = makeContext(mergeContext(nil, context))
+	for _, e := range c.Enrichers {
+		e.Enrich(context)
+	}
+	return context
+}
+
 func makeAnonymousId(anonymousId string) string {
 	if anonymousId == "" {
 		return uid()
@@ -140,6 +224,48 @@ func makeAnonymousId(anonymousId string) string {
 	}
 }
 
+// Same as makeAnonymousId but uses the anonymous id strategy of the client,
+// seeded with the value stored in ctx by `WithAnonymousIdSeed` or the device
+// id of the message context.
+func (c *client) makeAnonymousId(ctx context.Context, anonymousId string, userId string, context *Context) string {
+	if len(anonymousId) != 0 {
+		return anonymousId
+	}
+
+	seed := AnonymousIdSeedFromContext(ctx)
+	if len(seed) == 0 && context != nil {
+		seed = context.Device.Id
+	}
+
+	return c.AnonymousIdStrategy(userId, seed)
+}
+
+// Same as makeMessageId but the default id is generated by the message id
+// strategy of the client if one was configured, the message must have all its
+// other fields set.
+func (c *client) makeMessageId(id string, msg Message) string {
+	if len(id) != 0 {
+		return id
+	}
+
+	if c.MessageIdStrategy != nil {
+		if id = c.MessageIdStrategy(msg); len(id) != 0 {
+			return id
+		}
+	}
+
+	return c.uid()
+}
+
+// Records the sampling rate applied to a message on its context, so downstream
+// consumers can reweight the data.
+func setSampleRate(context *Context, rate float64) {
+	if context.Extra == nil {
+		context.Extra = make(map[string]interface{}, 1)
+	}
+	context.Extra["sampleRate"] = rate
+}
+
 func dereferenceMessage(msg Message) Message {
 	switch m := msg.(type) {
 	case *Alias:
@@ -183,72 +309,137 @@ func dereferenceMessage(msg Message) Message {
 	return msg
 }
 
-func (c *client) Enqueue(msg Message) (err error) {
-	msg = dereferenceMessage(msg)
+func (c *client) Enqueue(msg Message) error {
+	return c.EnqueueContext(context.Background(), msg)
+}
+
+func (c *client) EnqueueContext(ctx context.Context, msg Message) (err error) {
+	if msg, err = c.prepare(ctx, msg); err != nil {
+		return
+	}
+
+	defer func() {
+		// When the channels of the shard are closed writing to them will
+		// trigger a panic.
+		// To avoid letting the panic propagate to the caller we recover from it
+		// and instead report that the client has been closed and shouldn't be
+		// used anymore.
+		if recover() != nil {
+			err = ErrClosed
+		}
+		if err != nil {
+			c.discard(msg)
+		}
+	}()
+
+	s := c.shard(msg)
+	msgs := s.msgs
+	if c.Priority != nil && c.Priority(msg) == PriorityHigh {
+		msgs = s.high
+	}
+
+	qm := queuedMessage{msg: msg, at: time.Now()}
+
+	// Only wait for the context to be canceled when the queue is full, so
+	// messages are never discarded if there is room for them.
+	select {
+	case msgs <- qm:
+	default:
+		select {
+		case msgs <- qm:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	return
+}
+
+// Applies the configuration of the client to msg before it is queued, setting
+// all the fields that the application left empty. The method returns an error
+// if the message is invalid or must be dropped.
+func (c *client) prepare(ctx context.Context, msg Message) (_ Message, err error) {
+	msg = propagate(ctx, dereferenceMessage(msg))
 	if err = msg.Validate(); err != nil {
 		return
 	}
 
-	id := c.uid()
+	if c.suppressions.contains(messageUserId(msg)) {
+		err = ErrUserSuppressed
+		return
+	}
+
 	ts := c.now()
+	rate := 1.0
+
+	if c.Sampler != nil {
+		if rate, err = c.Sampler.sample(msg, ts); err != nil {
+			switch err {
+			case ErrSampled:
+				c.Metrics.Count(MetricMessagesSampled, 1)
+			case ErrRateLimited:
+				c.Metrics.Count(MetricMessagesRateLimited, 1)
+			}
+			return
+		}
+	}
 
 	switch m := msg.(type) {
 	case Alias:
 		m.Type = "alias"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	case Group:
 		m.Type = "group"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.AnonymousId = makeAnonymousId(m.AnonymousId)
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
+		m.AnonymousId = c.makeAnonymousId(ctx, m.AnonymousId, m.UserId, m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	case Identify:
 		m.Type = "identify"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	case Page:
 		m.Type = "page"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.AnonymousId = makeAnonymousId(m.AnonymousId)
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
+		m.AnonymousId = c.makeAnonymousId(ctx, m.AnonymousId, m.UserId, m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	case Screen:
 		m.Type = "screen"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.AnonymousId = makeAnonymousId(m.AnonymousId)
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
+		m.AnonymousId = c.makeAnonymousId(ctx, m.AnonymousId, m.UserId, m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	case Track:
 		m.Type = "track"
-		m.MessageId = makeMessageId(m.MessageId, id)
 		m.OriginalTimestamp = makeTimestamp(m.OriginalTimestamp, ts)
 		m.SentAt = m.OriginalTimestamp
-		m.AnonymousId = makeAnonymousId(m.AnonymousId)
-		m.Context = makeContext(m.Context)
+		m.Context = c.makeContext(m.Context)
+		m.AnonymousId = c.makeAnonymousId(ctx, m.AnonymousId, m.UserId, m.Context)
 		m.Channel = "server"
+		m.MessageId = c.makeMessageId(m.MessageId, m)
 		msg = m
 
 	default:
@@ -256,18 +447,25 @@ func (c *client) Enqueue(msg Message) (err error) {
 		return
 	}
 
-	defer func() {
-		// When the `msgs` channel is closed writing to it will trigger a panic.
-		// To avoid letting the panic propagate to the caller we recover from it
-		// and instead report that the client has been closed and shouldn't be
-		// used anymore.
-		if recover() != nil {
-			err = ErrClosed
-		}
-	}()
+	if rate < 1 {
+		setSampleRate(messageContext(msg), rate)
+	}
 
-	c.msgs <- msg
-	return
+	if c.dedup != nil && c.dedup.seen(messageIdOf(msg), ts) {
+		c.Metrics.Count(MetricMessagesDuplicate, 1)
+		err = ErrDuplicateMessage
+		return
+	}
+
+	return msg, nil
+}
+
+// Reverts the side effects of preparing a message that could not be queued,
+// so the next attempts to queue it are not considered to be duplicates.
+func (c *client) discard(msg Message) {
+	if c.dedup != nil {
+		c.dedup.forget(messageIdOf(msg))
+	}
 }
 
 // Close and flush metrics.
@@ -281,14 +479,27 @@ func (c *client) Close() (err error) {
 	}()
 	close(c.quit)
 	<-c.shutdown
+
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
 	return
 }
 
 // Asychronously send a batched requests.
-func (c *client) sendAsync(msgs []message, wg *sync.WaitGroup, ex *executor) {
+func (c *client) sendAsync(msgs []message, priority Priority, wg *sync.WaitGroup, ex *executor) {
+	if !c.trySendAsync(msgs, priority, wg, ex) {
+		c.errorf("sending messages failed - %s", ErrTooManyRequests)
+		c.notifyFailure(msgs, ErrTooManyRequests)
+	}
+}
+
+// Same as sendAsync but returns false instead of failing the messages when the
+// executor has no capacity left for them.
+func (c *client) trySendAsync(msgs []message, priority Priority, wg *sync.WaitGroup, ex *executor) bool {
 	wg.Add(1)
 
-	if !ex.do(func() {
+	if !ex.doWithPriority(func() {
 		defer wg.Done()
 		defer func() {
 			// In case a bug is introduced in the send function that triggers
@@ -299,11 +510,11 @@ func (c *client) sendAsync(msgs []message, wg *sync.WaitGroup, ex *executor) {
 			}
 		}()
 		c.send(msgs, 0)
-	}) {
+	}, priority) {
 		wg.Done()
-		c.errorf("sending messages failed - %s", ErrTooManyRequests)
-		c.notifyFailure(msgs, ErrTooManyRequests)
+		return false
 	}
+	return true
 }
 
 // Split based on Anonymous ID
@@ -396,11 +607,7 @@ func (c *client) send(msgs []message, retryAttempt int) {
 
 	ts := c.now()
 	for i := range msgs {
-		err := msgs[i].setSentAt(ts)
-		if err != nil {
-			c.errorf("%s - %v", err, msgs[i].msg)
-			c.notifyFailure([]message{msgs[i]}, err)
-		}
+		msgs[i].setSentAt(ts)
 	}
 
 	nodePayload := c.getNodePayload(msgs)
@@ -415,6 +622,12 @@ func (c *client) send(msgs []message, retryAttempt int) {
 				*/
 				continue
 			}
+			// While the circuit is open the batch is parked instead of being
+			// retried, it is sent again once the data plane recovered.
+			if c.breaker != nil && !c.breaker.allow(time.Now()) {
+				c.park(b)
+				break
+			}
 			targetNode := strconv.Itoa(k % c.totalNodes)
 			marshalB, err := c.getMarshalled(b)
 			if err != nil {
@@ -422,7 +635,7 @@ func (c *client) send(msgs []message, retryAttempt int) {
 				c.notifyFailure(b, err)
 				break
 			}
-			err = c.upload(marshalB, targetNode) // change the names of errors?
+			err = c.uploadBatch(marshalB, targetNode) // change the names of errors?
 			if err == nil {
 				c.notifySuccess(b)
 				break
@@ -462,6 +675,30 @@ func (c *client) send(msgs []message, retryAttempt int) {
 	}
 }
 
+// Uploads a serialized batch with the protocol of the client, the outcome is
+// reported to the adaptive concurrency limit, the circuit breaker and the
+// adaptive batching.
+func (c *client) uploadBatch(b []byte, targetNode string) error {
+	start := time.Now()
+	err := c.uploader.upload(b, targetNode)
+	latency, failed := time.Since(start), uploadFailed(err)
+
+	if c.Concurrency != nil {
+		limit := c.Concurrency.observe(start, latency, failed)
+		c.Metrics.Gauge(MetricConcurrencyLimit, float64(limit))
+	}
+
+	if c.breaker != nil {
+		c.breaker.record(failed, time.Now())
+	}
+
+	if c.batcher != nil && err == nil {
+		c.batcher.observe(latency)
+	}
+
+	return err
+}
+
 // Upload serialized batch message.
 func (c *client) upload(b []byte, targetNode string) error {
 	url := c.Endpoint + "/v1/batch"
@@ -469,29 +706,41 @@ func (c *client) upload(b []byte, targetNode string) error {
 		req      *http.Request
 		reqError error
 	)
-	if !c.Config.DisableGzip {
-		gzipPayload := func(data []byte) (io.Reader, error) {
-			var b bytes.Buffer
-			gz, err := gzip.NewWriterLevel(&b, gzip.BestSpeed)
+	compression := c.compression()
+	if comp := compressorOf(compression, c.CompressionLevel); comp != nil {
+		var body io.Reader
+		var pipe *io.PipeReader
+		streaming := len(b) > streamingThreshold
+
+		// Large batches are compressed while they're being sent so they don't
+		// have to be held in memory twice, the content length is then unknown
+		// and the request is sent with a chunked transfer encoding.
+		if streaming {
+			stream, err := comp.stream(b)
 			if err != nil {
-				return nil, err
+				c.errorf("%s payload - %s", compression, err)
+				return err
 			}
-			if _, err := gz.Write(data); err != nil {
-				return nil, err
-			}
-			if err = gz.Close(); err != nil {
-				return nil, err
+			body, pipe = stream, stream
+		} else {
+			payload, err := comp.body(b)
+			if err != nil {
+				c.errorf("%s payload - %s", compression, err)
+				return err
 			}
-			return &b, nil
+			body = payload
 		}
 
-		payload, err := gzipPayload(b)
-		if err != nil {
-			c.errorf("gzip payload - %s", err)
-			return err
+		req, reqError = http.NewRequest("POST", url, body)
+		if reqError != nil && pipe != nil {
+			pipe.CloseWithError(reqError)
+		}
+		if reqError == nil {
+			req.Header.Add("Content-Encoding", comp.encoding)
+			if streaming {
+				req.ContentLength = -1
+			}
 		}
-		req, reqError = http.NewRequest("POST", url, payload)
-		req.Header.Add("Content-Encoding", "gzip")
 	} else {
 		req, reqError = http.NewRequest("POST", url, bytes.NewReader(b))
 	}
@@ -503,7 +752,6 @@ func (c *client) upload(b []byte, targetNode string) error {
 
 	req.Header.Add("User-Agent", "analytics-go (version: "+Version+")")
 	req.Header.Add("Content-Type", "application/json")
-	req.Header.Add("Content-Length", strconv.Itoa(len(b)))
 	if !c.NoProxySupport {
 		req.Header.Add("RS-targetNode", targetNode)
 		req.Header.Add("RS-nodeCount", strconv.Itoa(c.totalNodes))
@@ -518,9 +766,27 @@ func (c *client) upload(b []byte, targetNode string) error {
 	}
 
 	defer res.Body.Close()
+
+	// Servers that don't support the compression respond with a 415 status,
+	// the batch is sent again with gzip which every server supports.
+	if res.StatusCode == http.StatusUnsupportedMediaType && compression != CompressionGzip && compression != CompressionNone {
+		c.errorf("%s compression is not supported by the server, falling back to gzip", compression)
+		c.negotiated.Store(int32(CompressionGzip))
+		return c.upload(b, targetNode)
+	}
+
 	return c.report(res)
 }
 
+// Returns the compression used to send requests, which is the configured one
+// unless the server rejected it.
+func (c *client) compression() Compression {
+	if negotiated := Compression(c.negotiated.Load()); negotiated != CompressionDefault {
+		return negotiated
+	}
+	return c.Compression
+}
+
 // Report on response body.
 func (c *client) report(res *http.Response) (err error) {
 	var body []byte
@@ -530,7 +796,7 @@ func (c *client) report(res *http.Response) (err error) {
 	}
 
 	if res.StatusCode == 451 {
-		return errors.New(strconv.Itoa(res.StatusCode))
+		return statusError{code: res.StatusCode}
 	}
 
 	if body, err = io.ReadAll(res.Body); err != nil {
@@ -539,74 +805,238 @@ func (c *client) report(res *http.Response) (err error) {
 	}
 
 	c.logf("response %d %s – %s", res.StatusCode, res.Status, string(body))
-	return fmt.Errorf("%d %s", res.StatusCode, res.Status)
+	return statusError{code: res.StatusCode, status: res.Status}
 }
 
 // Batch loop.
 func (c *client) loop() {
 	defer close(c.shutdown)
+	defer c.dropParked()
+	defer c.uploader.close()
 
 	wg := &sync.WaitGroup{}
 	defer wg.Wait()
 
-	tick := time.NewTicker(c.Interval)
-	defer tick.Stop()
-
 	ex := newExecutor(c.maxConcurrentRequests)
 	defer ex.close()
 
+	ex.adaptive = c.Concurrency
+
+	if c.Priority != nil {
+		ex.reserved = c.maxConcurrentRequests / 10
+	}
+
+	// The first shard is served by this goroutine and the others by their own,
+	// they all share the goroutines sending requests.
+	shards := sync.WaitGroup{}
+	for _, s := range c.shards[1:] {
+		shards.Add(1)
+		go func(s *shard) {
+			defer shards.Done()
+			c.shardLoop(s, wg, ex)
+		}(s)
+	}
+
+	c.shardLoop(c.shards[0], wg, ex)
+	shards.Wait()
+}
+
+// Batch loop of a shard.
+func (c *client) shardLoop(s *shard, wg *sync.WaitGroup, ex *executor) {
+	tick := time.NewTicker(c.Interval)
+	defer tick.Stop()
+
 	mq := messageQueue{
 		maxBatchSize:  c.BatchSize,
 		maxBatchBytes: c.maxBatchBytes(),
 	}
 
+	hq := messageQueue{
+		maxBatchSize:  c.HighPriorityBatchSize,
+		maxBatchBytes: c.maxBatchBytes(),
+		priority:      PriorityHigh,
+	}
+
+	// Flushes the normal queue when its oldest message waited for the flush
+	// delay, which is only used with adaptive batching or a maximum latency.
+	ft := flushTimer{}
+	defer ft.stop()
+
 	for {
+		// High priority messages are always handled before the others.
 		select {
-		case msg := <-c.msgs:
-			c.push(&mq, msg, wg, ex)
+		case msg := <-s.high:
+			c.pushHigh(s, &hq, msg, wg, ex)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-s.high:
+			c.pushHigh(s, &hq, msg, wg, ex)
+
+		case msg := <-s.msgs:
+			c.push(&mq, msg.msg, msg.at, wg, ex)
 
 		case <-tick.C:
+			// With adaptive batching the normal queue is only flushed by the
+			// flush timer.
+			if c.batcher == nil {
+				c.flush(&mq, wg, ex)
+			}
+
+			// Parked batches are only sent again by the first shard so the
+			// probes are not multiplied.
+			if s == c.shards[0] {
+				c.unpark(wg, ex)
+			}
+
+		case <-ft.C:
+			if c.batcher != nil {
+				c.batcher.elapsed(mq.bytes)
+			}
 			c.flush(&mq, wg, ex)
 
 		case <-c.quit:
 			c.debugf("exit requested – draining messages")
 
-			// Drain the msg channel, we have to close it first so no more
+			// Drain the msg channels, we have to close them first so no more
 			// messages can be pushed and otherwise the loop would never end.
-			close(c.msgs)
-			for msg := range c.msgs {
-				c.push(&mq, msg, wg, ex)
+			close(s.high)
+			close(s.msgs)
+			for msg := range s.high {
+				c.push(&hq, msg.msg, msg.at, wg, ex)
+			}
+			for msg := range s.msgs {
+				c.push(&mq, msg.msg, msg.at, wg, ex)
 			}
 
+			c.flush(&hq, wg, ex)
 			c.flush(&mq, wg, ex)
 			c.debugf("exit")
 			return
 		}
+
+		if delay := c.flushDelay(); delay != 0 {
+			ft.reset(mq.oldest, delay)
+		}
+	}
+}
+
+// Returns how long the oldest message of the normal queue waits before the
+// queue is flushed by the flush timer, zero if the timer isn't used.
+func (c *client) flushDelay() (delay time.Duration) {
+	if c.batcher != nil {
+		delay = c.batcher.currentInterval()
+	}
+	if c.MaxLatency != 0 && (delay == 0 || c.MaxLatency < delay) {
+		delay = c.MaxLatency
+	}
+	return
+}
+
+// Pushes a high priority message and all the ones queued after it, then
+// flushes the batch immediately instead of waiting for the flush interval.
+func (c *client) pushHigh(s *shard, q *messageQueue, m queuedMessage, wg *sync.WaitGroup, ex *executor) {
+	c.push(q, m.msg, m.at, wg, ex)
+
+	for {
+		select {
+		case m := <-s.high:
+			c.push(q, m.msg, m.at, wg, ex)
+		default:
+			c.flush(q, wg, ex)
+			return
+		}
 	}
 }
 
-func (c *client) push(q *messageQueue, m Message, wg *sync.WaitGroup, ex *executor) {
+func (c *client) push(q *messageQueue, m Message, at time.Time, wg *sync.WaitGroup, ex *executor) {
 	var msg message
 	var err error
 
 	if msg, err = makeMessage(m, c.MaxMessageBytes); err != nil {
 		c.errorf("%s - %v", err, m)
-		c.notifyFailure([]message{{m, nil}}, err)
+		c.notifyFailure([]message{{msg: m}}, err)
 		return
 	}
+	msg.queued = at
 
 	c.debugf("buffer (%d/%d) %v", len(q.pending), c.BatchSize, m)
 
 	if msgs := q.push(msg); msgs != nil {
 		c.debugf("exceeded messages batch limit with batch of %d messages – flushing", len(msgs))
-		c.sendAsync(msgs, wg, ex)
+		c.sendAsync(msgs, q.priority, wg, ex)
+	}
+
+	if c.batcher != nil && q.priority == PriorityNormal && c.batcher.full(q.bytes) {
+		c.debugf("reached target batch size with batch of %d messages – flushing", len(q.pending))
+		c.batcher.filled()
+		c.flush(q, wg, ex)
 	}
 }
 
 func (c *client) flush(q *messageQueue, wg *sync.WaitGroup, ex *executor) {
 	if msgs := q.flush(); msgs != nil {
 		c.debugf("flushing %d messages", len(msgs))
-		c.sendAsync(msgs, wg, ex)
+		c.sendAsync(msgs, q.priority, wg, ex)
+	}
+}
+
+// Parks a batch while the circuit is open, the batch fails if it couldn't be
+// parked.
+func (c *client) park(msgs []message) {
+	if err := c.breaker.park(msgs); err != nil {
+		c.errorf("%d messages dropped because they couldn't be parked - %s", len(msgs), err)
+		c.notifyFailure(msgs, ErrCircuitOpen)
+		return
+	}
+	c.debugf("%d messages parked until the circuit closes", len(msgs))
+}
+
+// Sends again the batches parked by the circuit breaker when it closed, or a
+// single one to probe the data plane while it is open. No more batches are
+// unparked than the executor accepts, the others wait for the next tick.
+func (c *client) unpark(wg *sync.WaitGroup, ex *executor) {
+	if c.breaker == nil {
+		return
+	}
+
+	batches, err := c.breaker.unpark(time.Now(), ex.free(PriorityNormal))
+	if err != nil {
+		c.errorf("restoring spilled messages - %s", err)
+	}
+
+	for i, batch := range batches {
+		if !c.trySendAsync(batch.msgs, PriorityNormal, wg, ex) {
+			c.breaker.repark(batches[i:])
+			return
+		}
+
+		if err := c.breaker.release(batch); err != nil {
+			c.errorf("removing spilled batch - %s", err)
+		}
+	}
+}
+
+// Fails the batches still parked in memory when the client is closed, spilled
+// batches are kept for the next process.
+func (c *client) dropParked() {
+	if c.breaker == nil {
+		return
+	}
+
+	for _, msgs := range c.breaker.drain() {
+		c.errorf("%d messages dropped because the circuit was open when the client was closed", len(msgs))
+		c.notifyFailure(msgs, ErrCircuitOpen)
+	}
+}
+
+func (c *client) circuitStateChanged(from CircuitState, to CircuitState) {
+	c.logf("circuit breaker %s -> %s", from, to)
+
+	if cb, ok := c.Callback.(CircuitBreakerCallback); ok {
+		cb.CircuitStateChanged(from, to)
 	}
 }
 
@@ -630,17 +1060,33 @@ func (c *client) maxBatchBytes() int {
 }
 
 func (c *client) notifySuccess(msgs []message) {
-	if c.Callback != nil {
-		for _, m := range msgs {
+	restored := 0
+	for _, m := range msgs {
+		// Messages restored from the spill directory only have their JSON
+		// representation and can't be reported to the callback.
+		switch {
+		case m.msg == nil:
+			restored++
+		case c.Callback != nil:
 			c.Callback.Success(m.msg)
 		}
 	}
+	if restored != 0 {
+		c.Metrics.Count(MetricSpilledMessagesSent, int64(restored))
+	}
 }
 
 func (c *client) notifyFailure(msgs []message, err error) {
-	if c.Callback != nil {
-		for _, m := range msgs {
+	restored := 0
+	for _, m := range msgs {
+		switch {
+		case m.msg == nil:
+			restored++
+		case c.Callback != nil:
 			c.Callback.Failure(m.msg, err)
 		}
 	}
+	if restored != 0 {
+		c.Metrics.Count(MetricSpilledMessagesDropped, int64(restored))
+	}
 }
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// This type represents functions used by clients to generate the anonymous id
+// of messages that don't have one.
+//
+// The function is called with the user id of the message and a seed, which is
+// the value set on the context.Context with `WithAnonymousIdSeed` or the
+// device id of the message context, and may be empty.
+// The function may return an empty string to leave the anonymous id unset,
+// which is only valid for messages that have a user id.
+type AnonymousIdStrategy func(userId string, seed string) string
+
+// The default anonymous id strategy, it ignores its arguments and returns a
+// random UUID.
+func RandomAnonymousId(userId string, seed string) string {
+	return uid()
+}
+
+// Returns an anonymous id strategy generating UUIDv5 from the namespace passed
+// as argument and the seed, so the same seed always maps to the same anonymous
+// id. This is useful when the seed identifies the visitor, like a device id or
+// a session cookie, and an operation may be retried.
+// A random UUID is returned if the seed is empty.
+func DeterministicAnonymousId(namespace uuid.UUID) AnonymousIdStrategy {
+	return func(userId string, seed string) string {
+		if len(seed) == 0 {
+			return uid()
+		}
+		return uuid.NewSHA1(namespace, []byte(seed)).String()
+	}
+}
+
+// Wraps an anonymous id strategy so no anonymous id is generated for messages
+// that have a user id.
+func OmitAnonymousIdWithUserId(strategy AnonymousIdStrategy) AnonymousIdStrategy {
+	return func(userId string, seed string) string {
+		if len(userId) != 0 {
+			return ""
+		}
+		return strategy(userId, seed)
+	}
+}
+
+type anonymousIdSeedContextKey struct{}
+
+// Returns a copy of ctx carrying the seed passed to the anonymous id strategy
+// when messages are queued with `EnqueueContext`.
+func WithAnonymousIdSeed(ctx context.Context, seed string) context.Context {
+	return context.WithValue(ctx, anonymousIdSeedContextKey{}, seed)
+}
+
+// Returns the seed stored in ctx by `WithAnonymousIdSeed`.
+func AnonymousIdSeedFromContext(ctx context.Context) string {
+	seed, _ := ctx.Value(anonymousIdSeedContextKey{}).(string)
+	return seed
+}
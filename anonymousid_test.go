@@ -0,0 +1,70 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestDeterministicAnonymousId(t *testing.T) {
+	strategy := DeterministicAnonymousId(uuid.NameSpaceURL)
+
+	a := strategy("", "device-1")
+	b := strategy("A", "device-1")
+	c := strategy("", "device-2")
+
+	if a != b {
+		t.Errorf("the same seed produced different anonymous ids: %s != %s", a, b)
+	}
+
+	if a == c {
+		t.Error("different seeds produced the same anonymous id:", a)
+	}
+
+	if id, err := uuid.Parse(a); err != nil || id.Version() != 5 {
+		t.Errorf("the anonymous id is not a UUIDv5: %s", a)
+	}
+
+	if strategy("", "") == strategy("", "") {
+		t.Error("anonymous ids generated without seed should be random")
+	}
+}
+
+func TestOmitAnonymousIdWithUserId(t *testing.T) {
+	strategy := OmitAnonymousIdWithUserId(RandomAnonymousId)
+
+	if id := strategy("A", ""); id != "" {
+		t.Error("an anonymous id was generated for a message with a user id:", id)
+	}
+
+	if id := strategy("", ""); id == "" {
+		t.Error("no anonymous id was generated for a message without a user id")
+	}
+}
+
+func TestClientAnonymousIdStrategy(t *testing.T) {
+	strategy := DeterministicAnonymousId(uuid.NameSpaceURL)
+
+	c := &client{
+		Config: makeConfig(Config{
+			AnonymousIdStrategy: strategy,
+		}),
+	}
+
+	ctx := WithAnonymousIdSeed(context.Background(), "cookie")
+
+	if id := c.makeAnonymousId(ctx, "", "A", nil); id != strategy("A", "cookie") {
+		t.Error("the seed of the context was not used:", id)
+	}
+
+	device := &Context{Device: DeviceInfo{Id: "device"}}
+
+	if id := c.makeAnonymousId(context.Background(), "", "A", device); id != strategy("A", "device") {
+		t.Error("the device id was not used as seed:", id)
+	}
+
+	if id := c.makeAnonymousId(ctx, "B", "A", device); id != "B" {
+		t.Error("the anonymous id of the message was overwritten:", id)
+	}
+}
@@ -0,0 +1,184 @@
+package analytics
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// This constant sets the default size of the batches that clients aim for with
+// adaptive batching if none was explicitly set.
+const DefaultTargetBatchBytes = 64 * 1024
+
+// Instances of this type configure the adaptive batching of clients, see
+// `Config.Batching`.
+//
+// With adaptive batching the normal queue is flushed as soon as it reaches the
+// target size, so bursts of traffic are sent early in batches of a steady
+// size. When the traffic is low and the flush interval elapses before the
+// queue reaches half the target size, the interval is doubled, up to
+// `MaxInterval`, so idle clients send fewer and larger batches. The interval
+// goes back to `Config.Interval` as soon as a batch reaches the target size.
+//
+// The flush interval is measured from the time the oldest message of the
+// batch was queued, set `Config.MaxLatency` to bound it.
+type AdaptiveBatching struct {
+
+	// The size in bytes of the batches that the client aims for, defaults to
+	// `DefaultTargetBatchBytes` and is capped to the maximum batch size.
+	TargetBatchBytes int
+
+	// The upload latency that the client aims for. When set the target size is
+	// decreased when uploads are slower and increased when they're faster than
+	// half the latency, between a quarter of `TargetBatchBytes` and the
+	// maximum batch size.
+	TargetLatency time.Duration
+
+	// The longest interval between flushes that the client stretches to when
+	// the traffic is low, defaults to ten times `Config.Interval`.
+	MaxInterval time.Duration
+}
+
+// Verifies that the fields of the adaptive batching are set to valid values.
+func (b *AdaptiveBatching) validate() error {
+	switch {
+	case b.TargetBatchBytes < 0:
+		return ConfigError{
+			Reason: "negative batch sizes are not supported",
+			Field:  "Batching.TargetBatchBytes",
+			Value:  b.TargetBatchBytes,
+		}
+	case b.TargetLatency < 0:
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "Batching.TargetLatency",
+			Value:  b.TargetLatency,
+		}
+	case b.MaxInterval < 0:
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "Batching.MaxInterval",
+			Value:  b.MaxInterval,
+		}
+	}
+	return nil
+}
+
+// The state of the adaptive batching of a client, it is shared by the shards
+// of the client and the goroutines sending batches.
+type batcher struct {
+	target    atomic.Int64
+	minTarget int64
+	maxTarget int64
+	latency   time.Duration
+
+	interval    atomic.Int64
+	minInterval time.Duration
+	maxInterval time.Duration
+}
+
+func newBatcher(config *AdaptiveBatching, interval time.Duration, maxBatchBytes int) *batcher {
+	b := &batcher{
+		maxTarget:   int64(maxBatchBytes),
+		latency:     config.TargetLatency,
+		minInterval: interval,
+		maxInterval: makeDuration(config.MaxInterval, 10*interval),
+	}
+
+	target := int64(makeInt(config.TargetBatchBytes, DefaultTargetBatchBytes))
+	if target > b.maxTarget {
+		target = b.maxTarget
+	}
+	b.target.Store(target)
+	b.minTarget = target / 4
+	b.interval.Store(int64(interval))
+
+	if b.maxInterval < interval {
+		b.maxInterval = interval
+	}
+	return b
+}
+
+// Returns the current flush interval.
+func (b *batcher) currentInterval() time.Duration {
+	return time.Duration(b.interval.Load())
+}
+
+// Returns true if a queue of the given size has reached the target size.
+func (b *batcher) full(bytes int) bool {
+	return int64(bytes) >= b.target.Load()
+}
+
+// Called when a queue was flushed because it reached the target size, the
+// traffic is high so the interval goes back to its minimum.
+func (b *batcher) filled() {
+	b.interval.Store(int64(b.minInterval))
+}
+
+// Called when a queue of the given size was flushed because the interval
+// elapsed, the interval is stretched if the traffic is low.
+func (b *batcher) elapsed(bytes int) {
+	if int64(bytes) < b.target.Load()/2 {
+		interval := 2 * b.currentInterval()
+		if interval > b.maxInterval {
+			interval = b.maxInterval
+		}
+		b.interval.Store(int64(interval))
+	}
+}
+
+// Adjusts the target size to the latency of an upload.
+func (b *batcher) observe(latency time.Duration) {
+	if b.latency == 0 {
+		return
+	}
+
+	for {
+		old := b.target.Load()
+		target := old
+
+		switch {
+		case latency > b.latency:
+			if target -= target / 4; target < b.minTarget {
+				target = b.minTarget
+			}
+		case latency < b.latency/2:
+			if target += target / 4; target > b.maxTarget {
+				target = b.maxTarget
+			}
+		}
+
+		if target == old || b.target.CompareAndSwap(old, target) {
+			return
+		}
+	}
+}
+
+// This type is used by the batch loop to flush the normal queue once its oldest
+// message waited for the flush delay.
+type flushTimer struct {
+	C      <-chan time.Time
+	timer  *time.Timer
+	oldest time.Time
+}
+
+// Schedules the flush of a queue whose oldest message was queued at the given
+// time, the timer is stopped if the queue is empty.
+func (t *flushTimer) reset(oldest time.Time, delay time.Duration) {
+	if oldest.Equal(t.oldest) {
+		return
+	}
+
+	t.stop()
+
+	if !oldest.IsZero() {
+		t.timer = time.NewTimer(time.Until(oldest.Add(delay)))
+		t.C, t.oldest = t.timer.C, oldest
+	}
+}
+
+func (t *flushTimer) stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	t.C, t.timer, t.oldest = nil, nil, time.Time{}
+}
@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBatcherInterval(t *testing.T) {
+	b := newBatcher(&AdaptiveBatching{TargetBatchBytes: 100, MaxInterval: 4 * time.Second}, time.Second, 1000)
+
+	for _, expected := range []time.Duration{2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		if b.elapsed(10); b.currentInterval() != expected {
+			t.Errorf("the interval should have been stretched to %s: %s", expected, b.currentInterval())
+		}
+	}
+
+	if b.elapsed(60); b.currentInterval() != 4*time.Second {
+		t.Error("the interval should not be stretched when the batch is larger than half the target:", b.currentInterval())
+	}
+
+	if b.filled(); b.currentInterval() != time.Second {
+		t.Error("the interval should have been reset when the batch was filled:", b.currentInterval())
+	}
+}
+
+func TestBatcherTargetLatency(t *testing.T) {
+	b := newBatcher(&AdaptiveBatching{TargetBatchBytes: 1000, TargetLatency: 100 * time.Millisecond}, time.Second, 2000)
+
+	if b.observe(200 * time.Millisecond); b.target.Load() != 750 {
+		t.Error("the target should have been decreased after a slow upload:", b.target.Load())
+	}
+
+	for i := 0; i != 10; i++ {
+		b.observe(200 * time.Millisecond)
+	}
+
+	if target := b.target.Load(); target != 250 {
+		t.Error("the target should not go below a quarter of the configured target:", target)
+	}
+
+	if b.observe(70 * time.Millisecond); b.target.Load() != 250 {
+		t.Error("the target should not change when the latency is close to the target:", b.target.Load())
+	}
+
+	for i := 0; i != 10; i++ {
+		b.observe(10 * time.Millisecond)
+	}
+
+	if target := b.target.Load(); target != 2000 {
+		t.Error("the target should not go above the maximum batch size:", target)
+	}
+}
+
+func TestBatcherTargetCapped(t *testing.T) {
+	if b := newBatcher(&AdaptiveBatching{}, time.Second, 1000); b.target.Load() != 1000 || b.maxInterval != 10*time.Second {
+		t.Errorf("invalid defaults: target = %d, max interval = %s", b.target.Load(), b.maxInterval)
+	}
+}
+
+func TestBatchingConfigError(t *testing.T) {
+	tests := map[string]Config{
+		"Batching.TargetBatchBytes": {Batching: &AdaptiveBatching{TargetBatchBytes: -1}},
+		"Batching.TargetLatency":    {Batching: &AdaptiveBatching{TargetLatency: -1}},
+		"Batching.MaxInterval":      {Batching: &AdaptiveBatching{MaxInterval: -1}},
+		"MaxLatency":                {MaxLatency: -1},
+	}
+
+	for field, config := range tests {
+		t.Run(field, func(t *testing.T) {
+			_, err := NewWithConfig(WRITE_KEY, config)
+
+			if e, ok := err.(ConfigError); !ok || e.Field != field {
+				t.Error("invalid configuration error:", err)
+			}
+		})
+	}
+}
+
+// Returns a transport that writes the number of messages of each batch to the
+// channel, batches are not reported when the channel is full.
+func testBatchTransport(t *testing.T, batches chan<- int) http.RoundTripper {
+	return roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		var b struct {
+			Batch []json.RawMessage `json:"batch"`
+		}
+		if err := json.Unmarshal(gunzip(t, r.Body), &b); err != nil {
+			t.Error(err)
+		}
+		select {
+		case batches <- len(b.Batch):
+		default:
+		}
+		return testTransportOK.RoundTrip(r)
+	})
+}
+
+func TestClientMaxLatency(t *testing.T) {
+	batches := make(chan int, 1)
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Interval:       time.Hour,
+		MaxLatency:     10 * time.Millisecond,
+		Logger:         testLogger{t.Logf, t.Logf},
+		Transport:      testBatchTransport(t, batches),
+		NoProxySupport: true,
+	})
+	defer client.Close()
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+
+	select {
+	case n := <-batches:
+		if n != 1 {
+			t.Error("invalid number of messages sent:", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("the message was not sent within the maximum latency")
+	}
+}
+
+func TestClientAdaptiveBatching(t *testing.T) {
+	batches := make(chan int, 1)
+
+	msg := Track{UserId: "A", Event: "B", MessageId: "1"}
+	m, _ := makeMessage(msg, defMaxMessageBytes)
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Interval:       time.Hour,
+		Batching:       &AdaptiveBatching{TargetBatchBytes: 10 * len(m.json)},
+		Logger:         testLogger{t.Logf, t.Logf},
+		Transport:      testBatchTransport(t, batches),
+		NoProxySupport: true,
+	})
+	defer client.Close()
+
+	// The batch is flushed as soon as it reaches the target size, without
+	// waiting for the flush interval.
+	for i := 0; i != 20; i++ {
+		client.Enqueue(msg)
+	}
+
+	select {
+	case n := <-batches:
+		if n < 2 || n >= 10 {
+			t.Error("invalid number of messages sent:", n)
+		}
+	case <-time.After(time.Second):
+		t.Error("the batch was not flushed when it reached the target size")
+	}
+}
@@ -0,0 +1,421 @@
+package analytics
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// This constant sets the default number of consecutive failed uploads after
+// which the circuit breaker opens if none was explicitly set.
+const DefaultBreakerThreshold = 5
+
+// This constant sets the default interval between the probes sent while the
+// circuit breaker is open if none was explicitly set.
+const DefaultProbeInterval = 10 * time.Second
+
+// This constant sets the default number of batches parked in memory while the
+// circuit breaker is open if none was explicitly set.
+const DefaultMaxParkedBatches = 100
+
+// Instances of this type configure the circuit breaker that clients place
+// around the data plane, see `Config.CircuitBreaker`.
+//
+// The circuit opens after a number of consecutive uploads failed with a network
+// error, a 429 or a 5xx status. While it is open batches are not sent but
+// parked until the data plane recovers, instead of each batch being retried
+// independently. Once every probe interval a single batch is sent to probe the
+// data plane, the circuit closes when it succeeds and the parked batches are
+// sent again.
+type CircuitBreaker struct {
+
+	// The number of consecutive failed uploads after which the circuit opens,
+	// defaults to `DefaultBreakerThreshold`.
+	Threshold int
+
+	// The interval between the probes sent while the circuit is open, defaults
+	// to `DefaultProbeInterval`.
+	ProbeInterval time.Duration
+
+	// The maximum number of batches parked in memory while the circuit is
+	// open, the batches exceeding it fail with `ErrCircuitOpen`. Defaults to
+	// `DefaultMaxParkedBatches`, the setting is ignored when `SpillDir` is set.
+	MaxParkedBatches int
+
+	// When set, parked batches are written to files in this directory instead
+	// of being held in memory. Files left by a previous process are sent once
+	// the circuit is closed. The files are named after the write key and the
+	// endpoint of the client, so clients sending to different sources can
+	// share the directory.
+	//
+	// Only the JSON representation of the messages is written to the files,
+	// the messages restored from them are not reported to `Config.Callback`.
+	// Their outcome is reported with the `MetricSpilledMessagesSent` and
+	// `MetricSpilledMessagesDropped` metrics instead.
+	SpillDir string
+}
+
+// Verifies that the fields of the circuit breaker are set to valid values.
+func (b *CircuitBreaker) validate() error {
+	switch {
+	case b.Threshold < 0:
+		return ConfigError{
+			Reason: "negative thresholds are not supported",
+			Field:  "CircuitBreaker.Threshold",
+			Value:  b.Threshold,
+		}
+	case b.ProbeInterval < 0:
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "CircuitBreaker.ProbeInterval",
+			Value:  b.ProbeInterval,
+		}
+	case b.MaxParkedBatches < 0:
+		return ConfigError{
+			Reason: "negative batch counts are not supported",
+			Field:  "CircuitBreaker.MaxParkedBatches",
+			Value:  b.MaxParkedBatches,
+		}
+	}
+
+	if len(b.SpillDir) != 0 {
+		if info, err := os.Stat(b.SpillDir); err != nil || !info.IsDir() {
+			return ConfigError{
+				Reason: "the spill directory must exist",
+				Field:  "CircuitBreaker.SpillDir",
+				Value:  b.SpillDir,
+			}
+		}
+	}
+
+	return nil
+}
+
+// CircuitState values represent the states of a circuit breaker.
+type CircuitState int
+
+const (
+	// Batches are sent normally.
+	CircuitClosed CircuitState = iota
+
+	// Batches are parked until the next probe.
+	CircuitOpen
+
+	// A probe was sent and batches are parked until it completes.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// Callbacks implementing this interface are also notified when the circuit
+// breaker of the client changes state.
+type CircuitBreakerCallback interface {
+
+	// This method is called every time the circuit breaker transitions from a
+	// state to another.
+	CircuitStateChanged(from CircuitState, to CircuitState)
+}
+
+// The state of the circuit breaker of a client, it is shared by all the
+// goroutines sending batches.
+type breaker struct {
+	mutex     sync.Mutex
+	state     CircuitState
+	failures  int
+	probed    time.Time
+	threshold int
+	interval  time.Duration
+
+	// Batches parked in memory, or the directory where they're spilled.
+	parked    [][]message
+	maxParked int
+	spillDir  string
+	spillName string
+	spillSeq  uint64
+
+	// Called without the mutex held when the state of the breaker changed.
+	changed func(from CircuitState, to CircuitState)
+}
+
+// A batch unparked by the breaker, it is only removed from the spill directory
+// once it was accepted by the executor, see `breaker.release`.
+type parkedBatch struct {
+	msgs []message
+
+	// The spill file of the batch, empty for batches parked in memory.
+	path string
+}
+
+// The spill files of the breaker are prefixed with spillName, see
+// `spillNameOf`.
+func newBreaker(config *CircuitBreaker, spillName string, changed func(CircuitState, CircuitState)) *breaker {
+	return &breaker{
+		threshold: makeInt(config.Threshold, DefaultBreakerThreshold),
+		interval:  makeDuration(config.ProbeInterval, DefaultProbeInterval),
+		maxParked: makeInt(config.MaxParkedBatches, DefaultMaxParkedBatches),
+		spillDir:  config.SpillDir,
+		spillName: spillName,
+		changed:   changed,
+	}
+}
+
+// Returns the prefix of the spill files of a client, derived from its write key
+// and endpoint so a client never restores the batches of another source.
+func spillNameOf(writeKey string, endpoint string) string {
+	sum := sha256.Sum256([]byte(writeKey + "\x00" + endpoint))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// Returns true if a batch may be sent. While the circuit is open a single
+// batch is let through every probe interval.
+func (b *breaker) allow(now time.Time) bool {
+	b.mutex.Lock()
+	from := b.state
+
+	// Probes that never completed (because the request couldn't be created
+	// for example) are replaced once the probe interval elapsed.
+	ok := from == CircuitClosed || b.probeDue(now)
+	if ok && from != CircuitClosed {
+		b.state, b.probed = CircuitHalfOpen, now
+	}
+
+	to := b.state
+	b.mutex.Unlock()
+
+	if from != to {
+		b.changed(from, to)
+	}
+	return ok
+}
+
+// Returns true if the next probe can be sent, the method must be called with
+// the mutex held.
+func (b *breaker) probeDue(now time.Time) bool {
+	return b.state != CircuitClosed && now.Sub(b.probed) >= b.interval
+}
+
+// Records the outcome of an upload.
+func (b *breaker) record(failed bool, now time.Time) {
+	b.mutex.Lock()
+	from := b.state
+
+	if failed {
+		b.failures++
+		if from == CircuitHalfOpen || b.failures >= b.threshold {
+			b.state, b.probed = CircuitOpen, now
+		}
+	} else {
+		b.failures = 0
+		b.state = CircuitClosed
+	}
+
+	to := b.state
+	b.mutex.Unlock()
+
+	if from != to {
+		b.changed(from, to)
+	}
+}
+
+// Parks a batch until the circuit closes, it returns an error if the batch
+// couldn't be parked.
+func (b *breaker) park(msgs []message) error {
+	if len(b.spillDir) != 0 {
+		return b.spill(msgs)
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if len(b.parked) == b.maxParked {
+		return ErrCircuitOpen
+	}
+
+	b.parked = append(b.parked, msgs)
+	return nil
+}
+
+// Returns up to limit batches that can be sent again: any number of them when
+// the circuit is closed, a single one to use as probe when the next probe is
+// due, or none. The method is only called by the goroutine sending the parked
+// batches, so spilled batches are read without the mutex held.
+func (b *breaker) unpark(now time.Time, limit int) ([]parkedBatch, error) {
+	b.mutex.Lock()
+
+	switch {
+	case b.state == CircuitClosed:
+	case b.probeDue(now):
+		limit = min(limit, 1)
+	default:
+		limit = 0
+	}
+
+	if limit <= 0 {
+		b.mutex.Unlock()
+		return nil, nil
+	}
+
+	if len(b.spillDir) != 0 {
+		b.mutex.Unlock()
+		return b.restore(limit)
+	}
+
+	n := min(limit, len(b.parked))
+	batches := make([]parkedBatch, n)
+	for i, msgs := range b.parked[:n] {
+		batches[i] = parkedBatch{msgs: msgs}
+	}
+	b.parked = b.parked[n:]
+
+	b.mutex.Unlock()
+	return batches, nil
+}
+
+// Removes the file of a spilled batch once it was accepted for sending, it has
+// no effect on batches parked in memory.
+func (b *breaker) release(batch parkedBatch) error {
+	if len(batch.path) == 0 {
+		return nil
+	}
+	return os.Remove(batch.path)
+}
+
+// Parks again the batches that were unparked but couldn't be sent, ahead of
+// the batches parked since. Spilled batches are still in their files and are
+// restored again on the next call to unpark.
+func (b *breaker) repark(batches []parkedBatch) {
+	var msgs [][]message
+	for _, batch := range batches {
+		if len(batch.path) == 0 {
+			msgs = append(msgs, batch.msgs)
+		}
+	}
+
+	if len(msgs) == 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	b.parked = append(msgs, b.parked...)
+	b.mutex.Unlock()
+}
+
+// Removes all the batches parked in memory and returns them.
+func (b *breaker) drain() (batches [][]message) {
+	b.mutex.Lock()
+	batches, b.parked = b.parked, nil
+	b.mutex.Unlock()
+	return
+}
+
+// Spilled batches are stored in one file per batch, with one message per line
+// prefixed by the position of its sentAt value. The files are named after the
+// client, the time and the sequence number of the batch.
+const spillExt = ".batch"
+
+func (b *breaker) spill(msgs []message) error {
+	var buf bytes.Buffer
+
+	for _, m := range msgs {
+		buf.WriteString(strconv.Itoa(m.sentAt))
+		buf.WriteByte(' ')
+		buf.WriteString(strconv.Itoa(m.sentAtEnd))
+		buf.WriteByte(' ')
+		buf.Write(m.json)
+		buf.WriteByte('\n')
+	}
+
+	// The names sort in the order the batches were spilled, they are written
+	// to a temporary file first so partial batches are never restored.
+	name := fmt.Sprintf("%s-%019d-%06d", b.spillName, time.Now().UnixNano(), atomic.AddUint64(&b.spillSeq, 1)%1000000)
+	path := filepath.Join(b.spillDir, name)
+
+	if err := os.WriteFile(path+".tmp", buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path+spillExt)
+}
+
+// Reads up to n spilled batches, the files are left in place until the batches
+// are released.
+func (b *breaker) restore(n int) (batches []parkedBatch, err error) {
+	entries, err := os.ReadDir(b.spillDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if len(batches) == n {
+			break
+		}
+
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), b.spillName+"-") || !strings.HasSuffix(entry.Name(), spillExt) {
+			continue
+		}
+
+		path := filepath.Join(b.spillDir, entry.Name())
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return batches, err
+		}
+
+		msgs, err := parseSpilledBatch(data)
+		if err != nil {
+			// The file is renamed so it isn't read again on the next call.
+			os.Rename(path, path+".invalid")
+			return batches, fmt.Errorf("%s: %w", path, err)
+		}
+
+		batches = append(batches, parkedBatch{msgs: msgs, path: path})
+	}
+
+	return batches, nil
+}
+
+func parseSpilledBatch(data []byte) (msgs []message, err error) {
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+
+		fields := bytes.SplitN(line, []byte(" "), 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed spilled message: %q", line)
+		}
+
+		m := message{json: fields[2]}
+
+		if m.sentAt, err = strconv.Atoi(string(fields[0])); err != nil {
+			return nil, err
+		}
+
+		if m.sentAtEnd, err = strconv.Atoi(string(fields[1])); err != nil {
+			return nil, err
+		}
+
+		if m.sentAt < 0 || m.sentAt > m.sentAtEnd || m.sentAtEnd > len(m.json) {
+			return nil, fmt.Errorf("malformed spilled message: %q", line)
+		}
+
+		msgs = append(msgs, m)
+	}
+	return
+}
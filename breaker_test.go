@@ -0,0 +1,328 @@
+package analytics
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	var states []CircuitState
+	b := newBreaker(&CircuitBreaker{Threshold: 2, ProbeInterval: time.Second}, "test", func(from CircuitState, to CircuitState) {
+		states = append(states, to)
+	})
+	now := time.Now()
+
+	b.record(true, now)
+	if !b.allow(now) {
+		t.Error("the circuit should still be closed after a single failure")
+	}
+
+	b.record(true, now)
+	if b.allow(now) {
+		t.Error("the circuit should be open after two consecutive failures")
+	}
+
+	if !b.allow(now.Add(time.Second)) {
+		t.Error("a probe should be allowed once the probe interval elapsed")
+	}
+
+	if b.allow(now.Add(time.Second)) {
+		t.Error("a single probe should be allowed at a time")
+	}
+
+	b.record(true, now.Add(time.Second))
+	if b.allow(now.Add(time.Second)) {
+		t.Error("the circuit should be open again after the probe failed")
+	}
+
+	if !b.allow(now.Add(2 * time.Second)) {
+		t.Error("a probe should be allowed once the probe interval elapsed")
+	}
+
+	b.record(false, now.Add(2*time.Second))
+	if !b.allow(now.Add(2 * time.Second)) {
+		t.Error("the circuit should be closed after the probe succeeded")
+	}
+
+	expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitOpen, CircuitHalfOpen, CircuitClosed}
+	if !reflect.DeepEqual(states, expected) {
+		t.Errorf("invalid state transitions: %v", states)
+	}
+}
+
+func TestBreakerPark(t *testing.T) {
+	b := newBreaker(&CircuitBreaker{Threshold: 1, MaxParkedBatches: 2}, "test", func(CircuitState, CircuitState) {})
+	now := time.Now()
+	b.record(true, now)
+
+	for i := 0; i != 2; i++ {
+		if err := b.park([]message{{json: []byte("{}")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := b.park([]message{{json: []byte("{}")}}); err != ErrCircuitOpen {
+		t.Error("parking more batches than the maximum should fail:", err)
+	}
+
+	if batches, _ := b.unpark(now, 10); len(batches) != 0 {
+		t.Error("no batches should be unparked until the next probe:", len(batches))
+	}
+
+	probe, _ := b.unpark(now.Add(DefaultProbeInterval), 10)
+	if len(probe) != 1 {
+		t.Fatal("a single batch should be unparked to probe the data plane:", len(probe))
+	}
+
+	// The probe couldn't be sent, it is unparked again ahead of the others.
+	b.repark(probe)
+	b.record(false, now)
+
+	if batches, _ := b.unpark(now, 1); len(batches) != 1 || &batches[0].msgs[0] != &probe[0].msgs[0] {
+		t.Error("the batch parked again should be unparked first:", len(batches))
+	}
+
+	if batches, _ := b.unpark(now, 10); len(batches) != 1 {
+		t.Error("the remaining batches should be unparked once the circuit closed:", len(batches))
+	}
+}
+
+func TestBreakerSpill(t *testing.T) {
+	dir := t.TempDir()
+	b := newBreaker(&CircuitBreaker{SpillDir: dir}, "test", func(CircuitState, CircuitState) {})
+
+	var batches [][]message
+	for _, event := range []string{"A", "B"} {
+		m, err := makeMessage(Track{UserId: "A", Event: event, SentAt: time.Now()}, defMaxMessageBytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.msg = nil
+		batches = append(batches, []message{m, m})
+
+		if err := b.park(batches[len(batches)-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "test-0-invalid"+spillExt), []byte("invalid\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := b.unpark(time.Now(), 10); err == nil {
+		t.Error("restoring an invalid file should fail")
+	}
+
+	// The invalid file was set aside, the next call restores the batches.
+	restored, err := b.unpark(time.Now(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs [][]message
+	for _, batch := range restored {
+		msgs = append(msgs, batch.msgs)
+	}
+
+	if !reflect.DeepEqual(msgs, batches) {
+		t.Errorf("invalid batches restored:\n- expected: %v\n- found:    %v", batches, msgs)
+	}
+
+	// The files are kept until the batches are released.
+	if again, _ := b.unpark(time.Now(), 10); len(again) != len(batches) {
+		t.Error("the batches should be restored until they are released:", len(again))
+	}
+
+	for _, batch := range restored {
+		if err := b.release(batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || !strings.HasSuffix(entries[0].Name(), ".invalid") {
+		t.Error("the spilled batches should have been removed:", entries)
+	}
+}
+
+func TestBreakerSpillShared(t *testing.T) {
+	dir := t.TempDir()
+	config := &CircuitBreaker{SpillDir: dir}
+
+	a := newBreaker(config, spillNameOf("A", DefaultEndpoint), func(CircuitState, CircuitState) {})
+	b := newBreaker(config, spillNameOf("B", DefaultEndpoint), func(CircuitState, CircuitState) {})
+
+	m, err := makeMessage(Track{UserId: "A", Event: "B"}, defMaxMessageBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.msg = nil
+
+	if err := a.park([]message{m}); err != nil {
+		t.Fatal(err)
+	}
+
+	if batches, _ := b.unpark(time.Now(), 10); len(batches) != 0 {
+		t.Error("the batches of another client should not be restored:", len(batches))
+	}
+
+	if batches, _ := a.unpark(time.Now(), 10); len(batches) != 1 {
+		t.Error("the batches of the client should be restored:", len(batches))
+	}
+}
+
+func TestClientNotifyRestored(t *testing.T) {
+	metrics := &testMetrics{}
+	c := &client{Config: makeConfig(Config{Metrics: metrics})}
+
+	msgs := []message{{json: []byte(`{}`)}, {json: []byte(`{}`)}}
+	c.notifySuccess(msgs)
+	c.notifyFailure(msgs[:1], ErrCircuitOpen)
+
+	if n := metrics.count(MetricSpilledMessagesSent); n != 2 {
+		t.Error("invalid number of restored messages reported as sent:", n)
+	}
+
+	if n := metrics.count(MetricSpilledMessagesDropped); n != 1 {
+		t.Error("invalid number of restored messages reported as dropped:", n)
+	}
+}
+
+type testBreakerCallback struct {
+	mutex  sync.Mutex
+	states []CircuitState
+	sent   chan Message
+}
+
+func (cb *testBreakerCallback) Success(m Message) { cb.sent <- m }
+
+func (cb *testBreakerCallback) Failure(m Message, err error) {}
+
+func (cb *testBreakerCallback) CircuitStateChanged(from CircuitState, to CircuitState) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.states = append(cb.states, to)
+}
+
+func (cb *testBreakerCallback) state() CircuitState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if len(cb.states) == 0 {
+		return CircuitClosed
+	}
+	return cb.states[len(cb.states)-1]
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var down atomic.Bool
+	var requests atomic.Int32
+	down.Store(true)
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		requests.Add(1)
+		if down.Load() {
+			return &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+		return testTransportOK.RoundTrip(r)
+	})
+
+	callback := &testBreakerCallback{sent: make(chan Message, 1)}
+
+	client, err := NewWithConfig(WRITE_KEY, Config{
+		Interval:       10 * time.Millisecond,
+		Logger:         testLogger{t.Logf, t.Logf},
+		Transport:      transport,
+		Callback:       callback,
+		NoProxySupport: true,
+		CircuitBreaker: &CircuitBreaker{Threshold: 1, ProbeInterval: 50 * time.Millisecond},
+		RetryAfter:     func(int) time.Duration { return time.Millisecond },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+
+	for callback.state() == CircuitClosed {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The batch is parked instead of going through all the retry attempts.
+	time.Sleep(20 * time.Millisecond)
+	if n := requests.Load(); n != 1 {
+		t.Error("no requests should be sent while the circuit is open:", n)
+	}
+
+	down.Store(false)
+
+	select {
+	case <-callback.sent:
+	case <-time.After(time.Second):
+		t.Fatal("the parked batch was not sent after the data plane recovered")
+	}
+
+	callback.mutex.Lock()
+	defer callback.mutex.Unlock()
+
+	if expected := []CircuitState{CircuitOpen, CircuitHalfOpen, CircuitClosed}; !reflect.DeepEqual(callback.states, expected) {
+		t.Errorf("invalid state transitions: %v", callback.states)
+	}
+}
+
+func TestClientUnparkBounded(t *testing.T) {
+	metrics := &testMetrics{}
+
+	cl, err := NewWithConfig(WRITE_KEY, Config{
+		Interval:              10 * time.Millisecond,
+		Logger:                testLogger{t.Logf, t.Logf},
+		Transport:             testTransportDelayed,
+		Metrics:               metrics,
+		NoProxySupport:        true,
+		CircuitBreaker:        &CircuitBreaker{SpillDir: t.TempDir()},
+		maxConcurrentRequests: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	m, err := makeMessage(Track{UserId: "A", Event: "B"}, defMaxMessageBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.msg = nil
+
+	// The spilled batches exceed the capacity of the executor, they are
+	// restored over multiple ticks instead of failing.
+	c := cl.(*client)
+	for i := 0; i != 5; i++ {
+		if err := c.breaker.park([]message{m}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for metrics.count(MetricSpilledMessagesSent) != 5 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n := metrics.count(MetricSpilledMessagesSent); n != 5 {
+		t.Error("invalid number of restored messages sent:", n)
+	}
+
+	if n := metrics.count(MetricSpilledMessagesDropped); n != 0 {
+		t.Error("no restored messages should have been dropped:", n)
+	}
+}
@@ -0,0 +1,173 @@
+package analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression values are used to select the codec that clients use to
+// compress requests, see `Config.Compression`.
+type Compression int
+
+const (
+	// Requests are compressed with gzip, unless `Config.DisableGzip` is set.
+	CompressionDefault Compression = iota
+
+	// Requests are sent uncompressed.
+	CompressionNone
+
+	// Requests are compressed with gzip.
+	CompressionGzip
+
+	// Requests are compressed with zstd.
+	CompressionZstd
+
+	// Requests are compressed with brotli.
+	CompressionBrotli
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionDefault:
+		return "default"
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	case CompressionBrotli:
+		return "brotli"
+	}
+	return "unknown"
+}
+
+// Batches larger than this are compressed while the request is being sent
+// instead of being compressed in memory first.
+const streamingThreshold = 128 * 1024
+
+// The brotli quality used by clients, higher values compress better but are
+// too slow to be used on every request.
+const brotliQuality = 4
+
+type compressWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+}
+
+// A compressor holds a pool of writers of a codec, the writers are reset and
+// reused between requests.
+type compressor struct {
+	encoding string
+	writers  sync.Pool
+	new      func(io.Writer) (compressWriter, error)
+}
+
+func (c *compressor) get(w io.Writer) (compressWriter, error) {
+	if cw, ok := c.writers.Get().(compressWriter); ok {
+		cw.Reset(w)
+		return cw, nil
+	}
+	return c.new(w)
+}
+
+func (c *compressor) put(cw compressWriter) {
+	cw.Reset(nil)
+	c.writers.Put(cw)
+}
+
+// One compressor per gzip compression level, from gzip.HuffmanOnly to
+// gzip.BestCompression.
+var gzipCompressors = func() (compressors [gzip.BestCompression - gzip.HuffmanOnly + 1]*compressor) {
+	for i := range compressors {
+		level := i + gzip.HuffmanOnly
+		compressors[i] = &compressor{
+			encoding: "gzip",
+			new: func(w io.Writer) (compressWriter, error) {
+				return gzip.NewWriterLevel(w, level)
+			},
+		}
+	}
+	return
+}()
+
+var zstdCompressor = &compressor{
+	encoding: "zstd",
+	new: func(w io.Writer) (compressWriter, error) {
+		// Compressing a batch in a single goroutine is faster than spreading
+		// it over multiple ones since batches are small.
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	},
+}
+
+var brotliCompressor = &compressor{
+	encoding: "br",
+	new: func(w io.Writer) (compressWriter, error) {
+		return brotli.NewWriterLevel(w, brotliQuality), nil
+	},
+}
+
+// Returns the compressor of the codec, the level is only used by gzip. The
+// function returns nil if requests must not be compressed.
+func compressorOf(compression Compression, level int) *compressor {
+	switch compression {
+	case CompressionGzip:
+		return gzipCompressors[level-gzip.HuffmanOnly]
+	case CompressionZstd:
+		return zstdCompressor
+	case CompressionBrotli:
+		return brotliCompressor
+	}
+	return nil
+}
+
+// Compresses b, the returned body has a known length.
+func (c *compressor) body(b []byte) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, len(b)/4))
+
+	cw, err := c.get(buf)
+	if err != nil {
+		return nil, err
+	}
+	defer c.put(cw)
+
+	if _, err := cw.Write(b); err != nil {
+		return nil, err
+	}
+
+	if err := cw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// Returns a body that compresses b while it is being read, the length of the
+// body is unknown. The body must be closed if it isn't sent, so the goroutine
+// compressing b exits.
+func (c *compressor) stream(b []byte) (*io.PipeReader, error) {
+	r, w := io.Pipe()
+
+	cw, err := c.get(w)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		// If the request is aborted the reader side of the pipe is closed and
+		// the writes fail, which ends the goroutine.
+		_, err := cw.Write(b)
+		if err == nil {
+			err = cw.Close()
+		}
+		c.put(cw)
+		w.CloseWithError(err)
+	}()
+
+	return r, nil
+}
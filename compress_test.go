@@ -0,0 +1,288 @@
+package analytics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func gunzip(t *testing.T, r io.Reader) []byte {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func decompress(t *testing.T, encoding string, r io.Reader) []byte {
+	switch encoding {
+	case "gzip":
+		return gunzip(t, r)
+	case "zstd":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer d.Close()
+		r = d
+	case "br":
+		r = brotli.NewReader(r)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestGzipBody(t *testing.T) {
+	data := []byte(strings.Repeat("Hello World!", 100))
+
+	for level := gzip.HuffmanOnly; level <= gzip.BestCompression; level++ {
+		// Compress twice to exercise the pooled writers.
+		for i := 0; i != 2; i++ {
+			buf, err := compressorOf(CompressionGzip, level).body(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if b := gunzip(t, buf); !bytes.Equal(b, data) {
+				t.Errorf("invalid data decompressed at level %d: %q", level, b)
+			}
+		}
+	}
+}
+
+func TestGzipStream(t *testing.T) {
+	data := []byte(strings.Repeat("Hello World!", 100000))
+
+	body, err := compressorOf(CompressionGzip, gzip.BestSpeed).stream(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+
+	if b := gunzip(t, body); !bytes.Equal(b, data) {
+		t.Error("invalid data decompressed from the stream")
+	}
+}
+
+func TestGzipStreamAborted(t *testing.T) {
+	body, _ := compressorOf(CompressionGzip, gzip.BestSpeed).stream([]byte(strings.Repeat("Hello World!", 100000)))
+
+	// Closing the body without reading it must not leak the goroutine
+	// compressing the data, which would otherwise block forever.
+	body.Close()
+}
+
+func TestUploadStreamInvalidRequest(t *testing.T) {
+	c := &client{Config: makeConfig(Config{
+		DataPlaneUrl: "http://[::1",
+		Logger:       testLogger{t.Logf, t.Logf},
+	})}
+
+	before := runtime.NumGoroutine()
+
+	// The request can't be created with an invalid endpoint, the goroutine
+	// compressing the batch must exit.
+	large := []byte(`{"batch":"` + strings.Repeat("x", 2*streamingThreshold) + `"}`)
+	if err := c.upload(large, "0"); err == nil {
+		t.Fatal("uploading to an invalid endpoint should fail")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatal("the goroutine compressing the batch leaked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestUploadContentLength(t *testing.T) {
+	type request struct {
+		contentLength int64
+		header        string
+		body          []byte
+	}
+	reqs := make(chan request, 1)
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		reqs <- request{
+			contentLength: r.ContentLength,
+			header:        r.Header.Get("Content-Length"),
+			body:          gunzip(t, r.Body),
+		}
+		return testTransportOK.RoundTrip(r)
+	})
+
+	c := &client{
+		Config: makeConfig(Config{
+			Logger:           testLogger{t.Logf, t.Logf},
+			Transport:        transport,
+			CompressionLevel: gzip.BestCompression,
+		}),
+		http: makeHttpClient(transport, DefaultTimeout),
+	}
+
+	small := []byte(`{"batch":[]}`)
+
+	if err := c.upload(small, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := <-reqs
+	if req.header != "" {
+		t.Error("the Content-Length header should not be set explicitly:", req.header)
+	}
+	if compressed, _ := compressorOf(CompressionGzip, gzip.BestCompression).body(small); req.contentLength != int64(compressed.Len()) {
+		t.Errorf("invalid content length: %d != %d", req.contentLength, compressed.Len())
+	}
+	if !bytes.Equal(req.body, small) {
+		t.Errorf("invalid request body: %s", req.body)
+	}
+
+	large := []byte(`{"batch":["` + strings.Repeat("A", streamingThreshold) + `"]}`)
+
+	if err := c.upload(large, "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	req = <-reqs
+	if req.contentLength != -1 {
+		t.Error("the content length of a streamed body should be unknown:", req.contentLength)
+	}
+	if !bytes.Equal(req.body, large) {
+		t.Error("invalid streamed request body")
+	}
+}
+
+func TestCompressors(t *testing.T) {
+	small := []byte(strings.Repeat("Hello World!", 100))
+	large := []byte(strings.Repeat("Hello World!", 100000))
+
+	for _, compression := range []Compression{CompressionGzip, CompressionZstd, CompressionBrotli} {
+		t.Run(compression.String(), func(t *testing.T) {
+			comp := compressorOf(compression, gzip.BestSpeed)
+
+			// Compress twice to exercise the pooled writers.
+			for i := 0; i != 2; i++ {
+				buf, err := comp.body(small)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b := decompress(t, comp.encoding, buf); !bytes.Equal(b, small) {
+					t.Errorf("invalid data decompressed: %q", b)
+				}
+
+				body, err := comp.stream(large)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b := decompress(t, comp.encoding, body); !bytes.Equal(b, large) {
+					t.Error("invalid data decompressed from the stream")
+				}
+				body.Close()
+			}
+		})
+	}
+
+	if compressorOf(CompressionNone, gzip.BestSpeed) != nil {
+		t.Error("no compressor should be returned when compression is disabled")
+	}
+}
+
+func TestCompressionDefault(t *testing.T) {
+	if c := makeConfig(Config{}); c.Compression != CompressionGzip {
+		t.Error("gzip should be used by default:", c.Compression)
+	}
+
+	if c := makeConfig(Config{DisableGzip: true}); c.Compression != CompressionNone {
+		t.Error("requests should not be compressed when gzip is disabled:", c.Compression)
+	}
+
+	if c := makeConfig(Config{DisableGzip: true, Compression: CompressionZstd}); c.Compression != CompressionZstd {
+		t.Error("the configured compression should be used:", c.Compression)
+	}
+
+	if _, err := NewWithConfig(WRITE_KEY, Config{Compression: CompressionBrotli + 1}); err == nil {
+		t.Error("an unknown compression should be rejected")
+	}
+}
+
+func TestCompressionFallback(t *testing.T) {
+	encodings := make(chan string, 3)
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		encoding := r.Header.Get("Content-Encoding")
+		encodings <- encoding
+
+		if b := decompress(t, encoding, r.Body); string(b) != `{"batch":[]}` {
+			t.Errorf("invalid request body: %s", b)
+		}
+
+		if encoding != "gzip" {
+			return &http.Response{
+				Status:     "415 Unsupported Media Type",
+				StatusCode: http.StatusUnsupportedMediaType,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+		return testTransportOK.RoundTrip(r)
+	})
+
+	c := &client{
+		Config: makeConfig(Config{
+			Logger:      testLogger{t.Logf, t.Logf},
+			Transport:   transport,
+			Compression: CompressionZstd,
+		}),
+		http: makeHttpClient(transport, DefaultTimeout),
+	}
+
+	if err := c.upload([]byte(`{"batch":[]}`), "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if e1, e2 := <-encodings, <-encodings; e1 != "zstd" || e2 != "gzip" {
+		t.Errorf("the request should have been sent again with gzip: %s, %s", e1, e2)
+	}
+
+	// The fallback is remembered, later requests are sent with gzip directly.
+	if err := c.upload([]byte(`{"batch":[]}`), "0"); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := <-encodings; e != "gzip" {
+		t.Error("gzip should be used after falling back:", e)
+	}
+}
+
+func TestCompressionLevelInvalid(t *testing.T) {
+	if _, err := NewWithConfig(WRITE_KEY, Config{CompressionLevel: 10}); err == nil {
+		t.Error("an invalid compression level should be rejected")
+	}
+}
+
+func BenchmarkGzipBody(b *testing.B) {
+	data := []byte(strings.Repeat(`{"type":"track","event":"Order Completed","properties":{"total":27.5}},`, 1000))
+	b.ReportAllocs()
+
+	for i := 0; i != b.N; i++ {
+		if _, err := compressorOf(CompressionGzip, gzip.BestSpeed).body(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package analytics
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This constant sets the default maximum number of concurrent uploads of an
+// adaptive concurrency limit if none was explicitly set.
+const DefaultMaxConcurrency = 1000
+
+// This constant sets the default number of batches waiting for an upload slot
+// when the adaptive concurrency limit is reached if none was explicitly set.
+const DefaultConcurrencyQueueSize = 100
+
+// This constant sets the default upload latency above which the adaptive
+// concurrency limit is decreased if none was explicitly set.
+const DefaultLatencyThreshold = 2 * time.Second
+
+// This constant sets the default factor applied to the adaptive concurrency
+// limit when it is decreased if none was explicitly set.
+const DefaultConcurrencyBackoff = 0.5
+
+// An AdaptiveConcurrency is used by clients to adjust the number of batches
+// uploaded concurrently to the health of the data plane, see
+// `Config.Concurrency`.
+//
+// The limit follows an additive increase, multiplicative decrease policy: it
+// grows by one each time a full limit of uploads succeeded within the latency
+// threshold, and is multiplied by the backoff factor when an upload fails with
+// a network error, a 429 or a 5xx status, or when it is slower than the
+// threshold. Batches that don't fit within the limit wait in a bounded queue
+// instead of being dropped.
+//
+// The value holds the state of the limit, it must not be shared between
+// clients. The tenants of a pool share the limit of the pool.
+type AdaptiveConcurrency struct {
+
+	// The number of concurrent uploads allowed when the client starts, defaults
+	// to the minimum limit.
+	InitialLimit int
+
+	// The bounds of the limit, defaulting to 1 and `DefaultMaxConcurrency`.
+	MinLimit int
+	MaxLimit int
+
+	// The upload latency above which the limit is decreased, defaults to
+	// `DefaultLatencyThreshold`.
+	LatencyThreshold time.Duration
+
+	// The factor applied to the limit when it is decreased, between 0 and 1,
+	// defaults to `DefaultConcurrencyBackoff`.
+	Backoff float64
+
+	// The maximum number of batches waiting for an upload slot, the batches
+	// exceeding it fail with `ErrTooManyRequests`. Defaults to
+	// `DefaultConcurrencyQueueSize`.
+	QueueSize int
+
+	mutex     sync.Mutex
+	limit     float64
+	decreased time.Time
+}
+
+// Verifies that the fields of the adaptive concurrency are set to valid
+// values.
+func (a *AdaptiveConcurrency) validate() error {
+	switch {
+	case a.InitialLimit < 0:
+		return ConfigError{
+			Reason: "negative limits are not supported",
+			Field:  "Concurrency.InitialLimit",
+			Value:  a.InitialLimit,
+		}
+	case a.MinLimit < 0:
+		return ConfigError{
+			Reason: "negative limits are not supported",
+			Field:  "Concurrency.MinLimit",
+			Value:  a.MinLimit,
+		}
+	case a.MaxLimit < 0:
+		return ConfigError{
+			Reason: "negative limits are not supported",
+			Field:  "Concurrency.MaxLimit",
+			Value:  a.MaxLimit,
+		}
+	case a.minLimit() > a.maxLimit():
+		return ConfigError{
+			Reason: "the minimum limit must not be greater than the maximum limit",
+			Field:  "Concurrency.MinLimit",
+			Value:  a.MinLimit,
+		}
+	case a.InitialLimit != 0 && (a.InitialLimit < a.minLimit() || a.InitialLimit > a.maxLimit()):
+		return ConfigError{
+			Reason: "the initial limit must be between the minimum and maximum limits",
+			Field:  "Concurrency.InitialLimit",
+			Value:  a.InitialLimit,
+		}
+	case a.LatencyThreshold < 0:
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "Concurrency.LatencyThreshold",
+			Value:  a.LatencyThreshold,
+		}
+	case a.Backoff < 0 || a.Backoff >= 1:
+		return ConfigError{
+			Reason: "backoff factors must be between 0 and 1",
+			Field:  "Concurrency.Backoff",
+			Value:  a.Backoff,
+		}
+	case a.QueueSize < 0:
+		return ConfigError{
+			Reason: "negative queue sizes are not supported",
+			Field:  "Concurrency.QueueSize",
+			Value:  a.QueueSize,
+		}
+	}
+	return nil
+}
+
+func (a *AdaptiveConcurrency) minLimit() int {
+	if a.MinLimit == 0 {
+		return 1
+	}
+	return a.MinLimit
+}
+
+func (a *AdaptiveConcurrency) maxLimit() int {
+	if a.MaxLimit == 0 {
+		return DefaultMaxConcurrency
+	}
+	return a.MaxLimit
+}
+
+func (a *AdaptiveConcurrency) queueSize() int {
+	if a.QueueSize == 0 {
+		return DefaultConcurrencyQueueSize
+	}
+	return a.QueueSize
+}
+
+// Limit returns the number of batches that the client currently uploads
+// concurrently.
+func (a *AdaptiveConcurrency) Limit() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return int(a.current())
+}
+
+// Returns the current limit, initializing it on first use. The method must be
+// called with the mutex held.
+func (a *AdaptiveConcurrency) current() float64 {
+	if a.limit == 0 {
+		a.limit = float64(makeInt(a.InitialLimit, a.minLimit()))
+	}
+	return a.limit
+}
+
+// Adjusts the limit to the outcome of an upload started at the given time,
+// and returns the new limit.
+func (a *AdaptiveConcurrency) observe(start time.Time, latency time.Duration, failed bool) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	limit := a.current()
+
+	if failed || latency > makeDuration(a.LatencyThreshold, DefaultLatencyThreshold) {
+		// All the uploads in flight are likely to fail when the data plane is
+		// unhealthy, only those that were started after the last decrease are
+		// taken into account so the limit doesn't collapse at once.
+		if start.After(a.decreased) {
+			backoff := a.Backoff
+			if backoff == 0 {
+				backoff = DefaultConcurrencyBackoff
+			}
+			a.limit = math.Max(float64(a.minLimit()), math.Floor(limit*backoff))
+			a.decreased = start.Add(latency)
+		}
+	} else {
+		a.limit = math.Min(float64(a.maxLimit()), limit+1/limit)
+	}
+
+	return int(a.limit)
+}
+
+// Returns true if the outcome of an upload shows that the data plane is
+// overloaded.
+func uploadFailed(err error) bool {
+	var status statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	return err != nil
+}
+
+// Returns the integer passed as first argument, unless it's zero, in that case
+// the default value passed as second argument is returned.
+func makeInt(n int, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+// Returns the duration passed as first argument, unless it's zero, in that
+// case the default value passed as second argument is returned.
+func makeDuration(d time.Duration, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
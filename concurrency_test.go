@@ -0,0 +1,159 @@
+package analytics
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrencyIncrease(t *testing.T) {
+	a := &AdaptiveConcurrency{InitialLimit: 2, MaxLimit: 3}
+	now := time.Now()
+
+	if limit := a.Limit(); limit != 2 {
+		t.Fatal("invalid initial limit:", limit)
+	}
+
+	// The limit grows by one after a full limit of successful uploads.
+	a.observe(now, time.Millisecond, false)
+	if limit := a.observe(now, time.Millisecond, false); limit != 2 {
+		t.Error("the limit should not have grown yet:", limit)
+	}
+
+	if limit := a.observe(now, time.Millisecond, false); limit != 3 {
+		t.Error("the limit should have grown:", limit)
+	}
+
+	for i := 0; i != 10; i++ {
+		a.observe(now, time.Millisecond, false)
+	}
+
+	if limit := a.Limit(); limit != 3 {
+		t.Error("the limit should not exceed the maximum:", limit)
+	}
+}
+
+func TestAdaptiveConcurrencyDecrease(t *testing.T) {
+	a := &AdaptiveConcurrency{InitialLimit: 8, MinLimit: 2, LatencyThreshold: time.Second}
+	start := time.Now()
+
+	if limit := a.observe(start, time.Millisecond, true); limit != 4 {
+		t.Error("the limit should have been halved on failure:", limit)
+	}
+
+	// Uploads that were in flight when the limit was decreased are ignored.
+	if limit := a.observe(start, time.Millisecond, true); limit != 4 {
+		t.Error("the limit should not have been decreased twice:", limit)
+	}
+
+	if limit := a.observe(start.Add(time.Second), 2*time.Second, false); limit != 2 {
+		t.Error("the limit should have been halved on slow uploads:", limit)
+	}
+
+	if limit := a.observe(start.Add(time.Minute), time.Millisecond, true); limit != 2 {
+		t.Error("the limit should not go below the minimum:", limit)
+	}
+}
+
+func TestAdaptiveConcurrencyConfigError(t *testing.T) {
+	tests := map[string]*AdaptiveConcurrency{
+		"Concurrency.InitialLimit":     {InitialLimit: 20, MaxLimit: 10},
+		"Concurrency.MinLimit":         {MinLimit: 20, MaxLimit: 10},
+		"Concurrency.MaxLimit":         {MaxLimit: -1},
+		"Concurrency.LatencyThreshold": {LatencyThreshold: -1},
+		"Concurrency.Backoff":          {Backoff: 1},
+		"Concurrency.QueueSize":        {QueueSize: -1},
+	}
+
+	for field, concurrency := range tests {
+		t.Run(field, func(t *testing.T) {
+			_, err := NewWithConfig(WRITE_KEY, Config{Concurrency: concurrency})
+
+			if e, ok := err.(ConfigError); !ok || e.Field != field {
+				t.Error("invalid configuration error:", err)
+			}
+		})
+	}
+}
+
+func TestExecutorAdaptive(t *testing.T) {
+	ex := newExecutor(10)
+	ex.adaptive = &AdaptiveConcurrency{InitialLimit: 1, QueueSize: 2}
+	defer ex.close()
+
+	block := make(chan struct{})
+	order := make(chan int, 3)
+	wg := &sync.WaitGroup{}
+	wg.Add(3)
+
+	if !ex.do(func() { <-block; order <- 0; wg.Done() }) {
+		t.Fatal("failed pushing a task to an executor with available capacity")
+	}
+
+	// Tasks exceeding the limit are queued instead of being refused, high
+	// priority tasks are started first.
+	if !ex.do(func() { order <- 2; wg.Done() }) {
+		t.Error("the task should have been queued")
+	}
+
+	if !ex.doWithPriority(func() { order <- 1; wg.Done() }, PriorityHigh) {
+		t.Error("the high priority task should have been queued")
+	}
+
+	if ex.do(func() {}) {
+		t.Error("the executor should have refused a task when its queue is full")
+	}
+
+	close(block)
+	wg.Wait()
+
+	for i := 0; i != 3; i++ {
+		if n := <-order; n != i {
+			t.Errorf("task %d was run at position %d", n, i)
+		}
+	}
+}
+
+func TestClientAdaptiveConcurrency(t *testing.T) {
+	metrics := &testMetrics{}
+	calls := 0
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if calls++; calls == 1 {
+			return &http.Response{
+				Status:     "503 Service Unavailable",
+				StatusCode: http.StatusServiceUnavailable,
+				Body:       io.NopCloser(strings.NewReader("")),
+			}, nil
+		}
+		return testTransportOK.RoundTrip(r)
+	})
+
+	concurrency := &AdaptiveConcurrency{InitialLimit: 10}
+
+	client, err := NewWithConfig(WRITE_KEY, Config{
+		Logger:         testLogger{t.Logf, t.Logf},
+		Transport:      transport,
+		NoProxySupport: true,
+		Concurrency:    concurrency,
+		Metrics:        metrics,
+		RetryAfter:     func(int) time.Duration { return time.Millisecond },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+	client.Close()
+
+	if limit := concurrency.Limit(); limit != 5 {
+		t.Error("the limit should have been decreased after the 503 response:", limit)
+	}
+
+	if limit := metrics.gauge(MetricConcurrencyLimit); limit != 5 {
+		t.Error("invalid concurrency limit reported:", limit)
+	}
+}
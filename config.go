@@ -1,7 +1,10 @@
 package analytics
 
 import (
+	"compress/gzip"
+	"crypto/tls"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
@@ -30,9 +33,32 @@ type Config struct {
 	// The HTTP transport used by the client, this allows an application to
 	// redefine how requests are being sent at the HTTP level (for example,
 	// to change the connection pooling policy).
-	// If none is specified the client uses `http.DefaultTransport`.
+	// If none is specified the client uses a transport configured with the
+	// Proxy, TLSConfig and MaxIdleConns fields, which must not be set along
+	// with a custom transport.
 	Transport http.RoundTripper
 
+	// The maximum duration of the requests sent by the client, including
+	// reading the response.
+	// If not set the client uses `DefaultTimeout`.
+	Timeout time.Duration
+
+	// The function used by the client's transport to select the proxy of each
+	// request.
+	// If not set the client uses `http.ProxyFromEnvironment`.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// The TLS configuration used by the client's transport, this allows an
+	// application to trust a custom certificate authority or to authenticate
+	// with client certificates. The client uses a copy of the configuration,
+	// later changes to it have no effect.
+	TLSConfig *tls.Config
+
+	// The maximum number of idle connections to the data plane that the
+	// client's transport keeps open.
+	// If not set the client uses `DefaultMaxIdleConns`.
+	MaxIdleConns int
+
 	// The logger used by the client to output info or error messages when that
 	// are generated by background operations.
 	// If none is specified the client uses a standard logger that outputs to
@@ -50,6 +76,17 @@ type Config struct {
 	// which is independent from the number of embedded messages.
 	BatchSize int
 
+	// A function called by the client to assign a priority to each message
+	// when it is queued. High priority messages have their own queue and
+	// batches which are sent immediately, and a tenth of the concurrent
+	// requests is reserved for them. This setting is ignored by pools.
+	// If not set all messages have a normal priority.
+	Priority func(Message) Priority
+
+	// The maximum number of high priority messages that will be sent in one
+	// API call, defaults to the batch size.
+	HighPriorityBatchSize int
+
 	// When set to true the client will send more frequent and detailed messages
 	// to its logger.
 	Verbose bool
@@ -57,6 +94,10 @@ type Config struct {
 	// The default context set on each message sent by the client.
 	DefaultContext *Context
 
+	// The enrichers applied by the client to the context of each message, in
+	// order, when it is queued.
+	Enrichers []Enricher
+
 	// The retry policy used by the client to resend requests that have failed.
 	// The function is called with how many times the operation has been retried
 	// and is expected to return how long the client should wait before trying
@@ -64,6 +105,84 @@ type Config struct {
 	// If not set the client will fallback to use a default retry policy.
 	RetryAfter func(int) time.Duration
 
+	// The strategy used by the client to generate the anonymous id of messages
+	// that don't have one, see `AnonymousIdStrategy`.
+	// If not set the client generates random UUIDs.
+	AnonymousIdStrategy AnonymousIdStrategy
+
+	// The strategy used by the client to generate the id of messages that don't
+	// have one, see `MessageIdStrategy`.
+	// If not set the client generates random UUIDs.
+	MessageIdStrategy MessageIdStrategy
+
+	// When set to a positive duration the client remembers the ids of the
+	// messages it queued and drops the messages carrying an id that was already
+	// seen within this time window, `Enqueue` returns `ErrDuplicateMessage`
+	// for those.
+	// Deduplication is disabled by default.
+	DedupWindow time.Duration
+
+	// The maximum number of message ids remembered by the client when
+	// `DedupWindow` is set, `DefaultDedupCacheSize` is used by default.
+	DedupCacheSize int
+
+	// The sampler used by the client to drop a fraction of the messages before
+	// they are queued, `Enqueue` returns `ErrSampled` or `ErrRateLimited` for
+	// those.
+	// If not set all messages are kept.
+	Sampler *Sampler
+
+	// The duration after which the tenants of a pool that haven't queued any
+	// messages are evicted, see `NewPool`. This setting is ignored by regular
+	// clients.
+	// If not set the pool uses `DefaultIdleTimeout`.
+	IdleTimeout time.Duration
+
+	// The adaptive limit of the number of batches uploaded concurrently by the
+	// client, see `AdaptiveConcurrency`.
+	// If not set the client uploads up to 1000 batches concurrently and drops
+	// the batches exceeding it with `ErrTooManyRequests`.
+	Concurrency *AdaptiveConcurrency
+
+	// The circuit breaker placed by the client around the data plane, see
+	// `CircuitBreaker`. State transitions are reported to the logger, and to
+	// the callback if it implements `CircuitBreakerCallback`. This setting is
+	// ignored by pools.
+	// If not set each batch is retried independently.
+	CircuitBreaker *CircuitBreaker
+
+	// The adaptive batching policy of the client, see `AdaptiveBatching`. This
+	// setting is ignored by pools.
+	// If not set the client flushes its queue every `Interval`.
+	Batching *AdaptiveBatching
+
+	// The maximum duration that a message waits in the client's queue before
+	// its batch is flushed, measured from the call to `Enqueue`. This setting
+	// is ignored by pools.
+	// If not set messages wait up to `Interval`, or up to the maximum interval
+	// of the adaptive batching.
+	MaxLatency time.Duration
+
+	// The number of shards that the client splits its batching work in, each
+	// shard has its own queue and goroutine marshaling messages so `Enqueue`
+	// scales with the number of cores. Messages are assigned to shards by
+	// `UserId`, or by `AnonymousId` when they have no user id, so the messages
+	// of a user keep their order. The messages queued before `UserId` is known
+	// are not ordered with the messages queued after it. This setting is
+	// ignored by pools.
+	// If not set the client uses a single shard, `runtime.GOMAXPROCS(0)` is a
+	// good value for applications queuing many messages per second.
+	Shards int
+
+	// The protocol used by the client to send batches to the data plane, see
+	// `Protocol`. Pools only support `ProtocolBatch`.
+	// If not set each batch is sent in its own request.
+	Protocol Protocol
+
+	// The object used by the client to report metrics about its operations.
+	// If none is specified the metrics are discarded.
+	Metrics Metrics
+
 	// A function called by the client to generate unique message identifiers.
 	// The client uses a UUID generator if none is provided.
 	// This field is not exported and only exposed internally to let unit tests
@@ -97,6 +216,19 @@ type Config struct {
 
 	// Disable/enable gzip support.
 	DisableGzip bool
+
+	// The gzip compression level used to compress the requests, between
+	// gzip.HuffmanOnly and gzip.BestCompression. Since zero means the default
+	// level, gzip.NoCompression can't be selected, set `Compression` to
+	// `CompressionNone` to send uncompressed requests instead.
+	// If not set the client uses gzip.BestSpeed.
+	CompressionLevel int
+
+	// The codec used to compress the requests, if the server doesn't support
+	// it and responds with a 415 status the client falls back to gzip.
+	// If not set the client uses gzip, or no compression if DisableGzip is
+	// set.
+	Compression Compression
 }
 
 // This constant sets the default endpoint to which client instances send
@@ -108,6 +240,10 @@ const DefaultEndpoint = "https://hosted.rudderlabs.com"
 // none was explicitly set.
 const DefaultInterval = 5 * time.Second
 
+// This constant sets the default duration after which idle tenants are evicted
+// from pools if none was explicitly set.
+const DefaultIdleTimeout = 10 * time.Minute
+
 // This constant sets the default batch size used by client instances if none
 // was explicitly set.
 const DefaultBatchSize = 250
@@ -131,6 +267,14 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if c.HighPriorityBatchSize < 0 {
+		return ConfigError{
+			Reason: "negative batch sizes are not supported",
+			Field:  "HighPriorityBatchSize",
+			Value:  c.HighPriorityBatchSize,
+		}
+	}
+
 	if c.MaxMessageBytes < 0 {
 		return ConfigError{
 			Reason: "negetive value is not supported for MaxMessageBytes",
@@ -147,6 +291,133 @@ func (c *Config) validate() error {
 		}
 	}
 
+	if c.DedupWindow < 0 {
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "DedupWindow",
+			Value:  c.DedupWindow,
+		}
+	}
+
+	if c.DedupCacheSize < 0 {
+		return ConfigError{
+			Reason: "negative cache sizes are not supported",
+			Field:  "DedupCacheSize",
+			Value:  c.DedupCacheSize,
+		}
+	}
+
+	if c.CompressionLevel < gzip.HuffmanOnly || c.CompressionLevel > gzip.BestCompression {
+		return ConfigError{
+			Reason: "invalid gzip compression level",
+			Field:  "CompressionLevel",
+			Value:  c.CompressionLevel,
+		}
+	}
+
+	if c.Compression < CompressionDefault || c.Compression > CompressionBrotli {
+		return ConfigError{
+			Reason: "unknown compression",
+			Field:  "Compression",
+			Value:  c.Compression,
+		}
+	}
+
+	if c.Timeout < 0 {
+		return ConfigError{
+			Reason: "negative timeouts are not supported",
+			Field:  "Timeout",
+			Value:  c.Timeout,
+		}
+	}
+
+	if c.MaxIdleConns < 0 {
+		return ConfigError{
+			Reason: "negative connection counts are not supported",
+			Field:  "MaxIdleConns",
+			Value:  c.MaxIdleConns,
+		}
+	}
+
+	if c.Transport != nil {
+		switch {
+		case c.Proxy != nil:
+			return ConfigError{
+				Reason: "proxy cannot be set along with a custom transport",
+				Field:  "Proxy",
+				Value:  c.Proxy,
+			}
+		case c.TLSConfig != nil:
+			return ConfigError{
+				Reason: "TLS configuration cannot be set along with a custom transport",
+				Field:  "TLSConfig",
+				Value:  c.TLSConfig,
+			}
+		case c.MaxIdleConns != 0:
+			return ConfigError{
+				Reason: "idle connections cannot be set along with a custom transport",
+				Field:  "MaxIdleConns",
+				Value:  c.MaxIdleConns,
+			}
+		}
+	}
+
+	if c.IdleTimeout < 0 {
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "IdleTimeout",
+			Value:  c.IdleTimeout,
+		}
+	}
+
+	if c.Sampler != nil {
+		if err := c.Sampler.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Concurrency != nil {
+		if err := c.Concurrency.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Batching != nil {
+		if err := c.Batching.validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Shards < 0 {
+		return ConfigError{
+			Reason: "negative shard counts are not supported",
+			Field:  "Shards",
+			Value:  c.Shards,
+		}
+	}
+
+	if c.MaxLatency < 0 {
+		return ConfigError{
+			Reason: "negative time intervals are not supported",
+			Field:  "MaxLatency",
+			Value:  c.MaxLatency,
+		}
+	}
+
+	if c.Protocol < ProtocolBatch || c.Protocol > ProtocolStream {
+		return ConfigError{
+			Reason: "unknown protocol",
+			Field:  "Protocol",
+			Value:  c.Protocol,
+		}
+	}
+
 	return nil
 }
 
@@ -165,8 +436,16 @@ func makeConfig(c Config) Config {
 		c.Interval = DefaultInterval
 	}
 
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+	}
+
+	if c.MaxIdleConns == 0 {
+		c.MaxIdleConns = DefaultMaxIdleConns
+	}
+
 	if c.Transport == nil {
-		c.Transport = http.DefaultTransport
+		c.Transport = newTransport(c)
 	}
 
 	if c.Logger == nil {
@@ -177,6 +456,10 @@ func makeConfig(c Config) Config {
 		c.BatchSize = DefaultBatchSize
 	}
 
+	if c.HighPriorityBatchSize == 0 {
+		c.HighPriorityBatchSize = c.BatchSize
+	}
+
 	if c.DefaultContext == nil {
 		c.DefaultContext = &Context{}
 	}
@@ -185,6 +468,22 @@ func makeConfig(c Config) Config {
 		c.RetryAfter = backo.NewBacko(time.Millisecond*100, 2, 1, time.Second*30).Duration
 	}
 
+	if c.AnonymousIdStrategy == nil {
+		c.AnonymousIdStrategy = RandomAnonymousId
+	}
+
+	if c.DedupWindow != 0 && c.DedupCacheSize == 0 {
+		c.DedupCacheSize = DefaultDedupCacheSize
+	}
+
+	if c.IdleTimeout == 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+
+	if c.Metrics == nil {
+		c.Metrics = discardMetrics{}
+	}
+
 	if c.uid == nil {
 		c.uid = uid
 	}
@@ -205,10 +504,26 @@ func makeConfig(c Config) Config {
 		c.MaxBatchBytes = defMaxBatchBytes
 	}
 
+	if c.CompressionLevel == 0 {
+		c.CompressionLevel = gzip.BestSpeed
+	}
+
 	if c.Gzip != 0 {
 		c.DisableGzip = true
 	}
 
+	if c.Shards == 0 {
+		c.Shards = 1
+	}
+
+	if c.Compression == CompressionDefault {
+		if c.DisableGzip {
+			c.Compression = CompressionNone
+		} else {
+			c.Compression = CompressionGzip
+		}
+	}
+
 	// We always overwrite the 'library' field of the default context set on the
 	// client because we want this information to be accurate.
 	c.DefaultContext.Library = LibraryInfo{
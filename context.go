@@ -1,10 +1,6 @@
 package analytics
 
-import (
-	"encoding/json"
-	"net"
-	"reflect"
-)
+import "net"
 
 // This type provides the representation of the `context` object as defined in
 
@@ -133,17 +129,8 @@ type ScreenInfo struct {
 //
 // Related discussion: https://github.com/golang/go/issues/6213
 func (ctx Context) MarshalJSON() ([]byte, error) {
-	v := reflect.ValueOf(ctx)
-	n := v.NumField()
-	m := make(map[string]interface{}, n+len(ctx.Extra))
-
-	// Copy the `Extra` map into the map representation of the context, it is
-	// important to do this operation before going through the actual struct
-	// fields so the latter take precendence and override duplicated values
-	// that would be set in the extensions.
-	for name, value := range ctx.Extra {
-		m[name] = value
-	}
-
-	return json.Marshal(structToMap(v, m))
+	e := getEncoder()
+	defer putEncoder(e)
+	e.context(&ctx)
+	return e.bytes()
 }
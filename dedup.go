@@ -0,0 +1,53 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+)
+
+// This constant sets the default number of message ids remembered by clients
+// when the deduplication window is enabled and no cache size was explicitly
+// set.
+const DefaultDedupCacheSize = 10000
+
+// This type keeps track of the ids of messages queued recently, it is used by
+// clients to drop messages that are enqueued more than once within a time
+// window.
+// The memory used is bounded by the size of the cache, when it is full the ids
+// that were seen least recently are forgotten first.
+type dedupCache struct {
+	mutex  sync.Mutex
+	window time.Duration
+	cache  *lruCache
+}
+
+func newDedupCache(window time.Duration, size int) *dedupCache {
+	return &dedupCache{
+		window: window,
+		cache:  newLRUCache(size),
+	}
+}
+
+// Returns true if the id was already seen within the time window preceding
+// now, otherwise the id is recorded and the method returns false.
+func (d *dedupCache) seen(id string, now time.Time) bool {
+	if len(id) == 0 {
+		return false
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if v, ok := d.cache.get(id); ok && now.Sub(v.(time.Time)) < d.window {
+		return true
+	}
+
+	d.cache.add(id, now)
+	return false
+}
+
+// Forgets about the id, so the next message carrying it isn't considered to be
+// a duplicate.
+func (d *dedupCache) forget(id string) {
+	d.cache.remove(id)
+}
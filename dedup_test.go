@@ -0,0 +1,116 @@
+package analytics
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Instances of this type are used to record the metrics reported by clients.
+type testMetrics struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+	gauges map[string]float64
+}
+
+func (m *testMetrics) Count(name string, value int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.counts == nil {
+		m.counts = make(map[string]int64)
+	}
+	m.counts[name] += value
+}
+
+func (m *testMetrics) Gauge(name string, value float64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.gauges == nil {
+		m.gauges = make(map[string]float64)
+	}
+	m.gauges[name] = value
+}
+
+func (m *testMetrics) count(name string) int64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.counts[name]
+}
+
+func (m *testMetrics) gauge(name string) float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.gauges[name]
+}
+
+func TestDedupCacheWindow(t *testing.T) {
+	d := newDedupCache(time.Minute, 10)
+	now := time.Now()
+
+	if d.seen("A", now) {
+		t.Error("the first occurrence of an id was reported as a duplicate")
+	}
+
+	if !d.seen("A", now.Add(30*time.Second)) {
+		t.Error("a repeated id within the window was not reported as a duplicate")
+	}
+
+	if d.seen("A", now.Add(2*time.Minute)) {
+		t.Error("a repeated id after the window was reported as a duplicate")
+	}
+
+	if d.seen("", now) || d.seen("", now) {
+		t.Error("empty ids should never be reported as duplicates")
+	}
+}
+
+func TestDedupCacheForget(t *testing.T) {
+	d := newDedupCache(time.Minute, 10)
+	now := time.Now()
+
+	d.seen("A", now)
+	d.forget("A")
+
+	if d.seen("A", now) {
+		t.Error("a forgotten id was reported as a duplicate")
+	}
+}
+
+func TestEnqueueDuplicate(t *testing.T) {
+	metrics := &testMetrics{}
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Endpoint:    "http://localhost:9999",
+		Logger:      testLogger{t.Logf, t.Logf},
+		Transport:   testTransportOK,
+		DedupWindow: time.Minute,
+		Metrics:     metrics,
+	})
+	defer client.Close()
+
+	if err := client.Enqueue(Track{MessageId: "1", UserId: "A", Event: "Download"}); err != nil {
+		t.Fatal("queuing the first message failed:", err)
+	}
+
+	if err := client.Enqueue(Track{MessageId: "1", UserId: "A", Event: "Download"}); err != ErrDuplicateMessage {
+		t.Error("invalid error returned for a duplicate message:", err)
+	}
+
+	if err := client.Enqueue(Track{MessageId: "2", UserId: "A", Event: "Download"}); err != nil {
+		t.Error("queuing a different message failed:", err)
+	}
+
+	if n := metrics.count(MetricMessagesDuplicate); n != 1 {
+		t.Error("invalid number of duplicate messages reported:", n)
+	}
+}
+
+func TestDedupConfigInvalid(t *testing.T) {
+	if _, err := NewWithConfig(WRITE_KEY, Config{DedupWindow: -1}); err == nil {
+		t.Error("a negative deduplication window should be rejected")
+	}
+
+	if _, err := NewWithConfig(WRITE_KEY, Config{DedupCacheSize: -1}); err == nil {
+		t.Error("a negative deduplication cache size should be rejected")
+	}
+}
@@ -0,0 +1,223 @@
+package analytics
+
+import "reflect"
+
+// Names of the standard events defined by the E-commerce spec.
+const (
+	EventProductsSearched  = "Products Searched"
+	EventProductListViewed = "Product List Viewed"
+	EventProductClicked    = "Product Clicked"
+	EventProductViewed     = "Product Viewed"
+	EventProductAdded      = "Product Added"
+	EventProductRemoved    = "Product Removed"
+	EventCartViewed        = "Cart Viewed"
+	EventCheckoutStarted   = "Checkout Started"
+	EventOrderUpdated      = "Order Updated"
+	EventOrderCompleted    = "Order Completed"
+	EventOrderRefunded     = "Order Refunded"
+	EventOrderCancelled    = "Order Cancelled"
+	EventCouponApplied     = "Coupon Applied"
+)
+
+// This type represents the order-level properties of the checkout and order
+// events of the E-commerce spec.
+type Order struct {
+	OrderId     string    `json:"order_id,omitempty"`
+	CheckoutId  string    `json:"checkout_id,omitempty"`
+	Affiliation string    `json:"affiliation,omitempty"`
+	Total       float64   `json:"total,omitempty"`
+	Subtotal    float64   `json:"subtotal,omitempty"`
+	Revenue     float64   `json:"revenue,omitempty"`
+	Shipping    float64   `json:"shipping,omitempty"`
+	Tax         float64   `json:"tax,omitempty"`
+	Discount    float64   `json:"discount,omitempty"`
+	Coupon      string    `json:"coupon,omitempty"`
+	Currency    string    `json:"currency,omitempty"`
+	Products    []Product `json:"products,omitempty"`
+}
+
+// This type represents the properties of the cart events of the E-commerce
+// spec.
+type Cart struct {
+	CartId   string    `json:"cart_id,omitempty"`
+	Products []Product `json:"products,omitempty"`
+}
+
+// This type represents the properties of the product list events of the
+// E-commerce spec.
+type ProductList struct {
+	ListId   string    `json:"list_id,omitempty"`
+	Category string    `json:"category,omitempty"`
+	Products []Product `json:"products,omitempty"`
+}
+
+// This type represents the properties of the `Coupon Applied` event of the
+// E-commerce spec.
+type Coupon struct {
+	OrderId    string  `json:"order_id,omitempty"`
+	CartId     string  `json:"cart_id,omitempty"`
+	CouponId   string  `json:"coupon_id,omitempty"`
+	CouponName string  `json:"coupon_name,omitempty"`
+	Discount   float64 `json:"discount,omitempty"`
+}
+
+// The functions below build the track messages of the E-commerce spec, the
+// application only has to set the user identifiers before enqueuing them:
+//
+//	track, err := analytics.NewOrderCompleted(analytics.Order{
+//		OrderId:  "50314b8e9bcf000000000000",
+//		Total:    27.50,
+//		Currency: "USD",
+//		Products: []analytics.Product{{ID: "507f1f77bcf86cd799439011", Price: 19}},
+//	})
+//	if err != nil {
+//		...
+//	}
+//	track.UserId = "0123456789"
+//	client.Enqueue(track)
+//
+// The functions return a FieldError when a property required by the spec is
+// missing.
+
+func NewProductsSearched(query string) (Track, error) {
+	if len(query) == 0 {
+		return Track{}, FieldError{
+			Type:  "analytics.ProductsSearched",
+			Name:  "Query",
+			Value: query,
+		}
+	}
+	return specTrack(EventProductsSearched, NewProperties().Set("query", query)), nil
+}
+
+func NewProductListViewed(list ProductList) (Track, error) {
+	if err := validateProducts("analytics.ProductListViewed", list.Products); err != nil {
+		return Track{}, err
+	}
+	return specTrack(EventProductListViewed, specProperties(list)), nil
+}
+
+func NewProductClicked(product Product) (Track, error) {
+	return productTrack(EventProductClicked, "", product)
+}
+
+func NewProductViewed(product Product) (Track, error) {
+	return productTrack(EventProductViewed, "", product)
+}
+
+func NewProductAdded(cartId string, product Product) (Track, error) {
+	return productTrack(EventProductAdded, cartId, product)
+}
+
+func NewProductRemoved(cartId string, product Product) (Track, error) {
+	return productTrack(EventProductRemoved, cartId, product)
+}
+
+func NewCartViewed(cart Cart) (Track, error) {
+	if err := validateProducts("analytics.CartViewed", cart.Products); err != nil {
+		return Track{}, err
+	}
+	return specTrack(EventCartViewed, specProperties(cart)), nil
+}
+
+func NewCheckoutStarted(order Order) (Track, error) {
+	if err := validateProducts("analytics.CheckoutStarted", order.Products); err != nil {
+		return Track{}, err
+	}
+	return specTrack(EventCheckoutStarted, specProperties(order)), nil
+}
+
+func NewOrderUpdated(order Order) (Track, error) {
+	return orderTrack(EventOrderUpdated, "analytics.OrderUpdated", order)
+}
+
+func NewOrderCompleted(order Order) (Track, error) {
+	return orderTrack(EventOrderCompleted, "analytics.OrderCompleted", order)
+}
+
+func NewOrderRefunded(order Order) (Track, error) {
+	return orderTrack(EventOrderRefunded, "analytics.OrderRefunded", order)
+}
+
+func NewOrderCancelled(order Order) (Track, error) {
+	return orderTrack(EventOrderCancelled, "analytics.OrderCancelled", order)
+}
+
+func NewCouponApplied(coupon Coupon) (Track, error) {
+	if len(coupon.CouponId) == 0 {
+		return Track{}, FieldError{
+			Type:  "analytics.CouponApplied",
+			Name:  "CouponId",
+			Value: coupon.CouponId,
+		}
+	}
+	return specTrack(EventCouponApplied, specProperties(coupon)), nil
+}
+
+func productTrack(event string, cartId string, product Product) (Track, error) {
+	if err := product.Validate(); err != nil {
+		return Track{}, err
+	}
+
+	// The spec names the id "product_id" at the top level of the event
+	// properties.
+	props := specProperties(product)
+	if id, ok := props["id"]; ok {
+		delete(props, "id")
+		props.Set("product_id", id)
+	}
+	if len(cartId) != 0 {
+		props.Set("cart_id", cartId)
+	}
+	return specTrack(event, props), nil
+}
+
+func orderTrack(event string, typ string, order Order) (Track, error) {
+	if len(order.OrderId) == 0 {
+		return Track{}, FieldError{
+			Type:  typ,
+			Name:  "OrderId",
+			Value: order.OrderId,
+		}
+	}
+
+	for _, p := range order.Products {
+		if err := p.Validate(); err != nil {
+			return Track{}, err
+		}
+	}
+
+	return specTrack(event, specProperties(order)), nil
+}
+
+// Validates a list of products that the spec requires to be non-empty.
+func validateProducts(typ string, products []Product) error {
+	if len(products) == 0 {
+		return FieldError{
+			Type:  typ,
+			Name:  "Products",
+			Value: len(products),
+		}
+	}
+
+	for _, p := range products {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func specTrack(event string, props Properties) Track {
+	return Track{
+		Event:      event,
+		Properties: props,
+	}
+}
+
+// Converts one of the spec types to properties, using the JSON tags of the
+// struct fields as property names.
+func specProperties(v interface{}) Properties {
+	return Properties(structToMap(reflect.ValueOf(v), nil))
+}
@@ -0,0 +1,159 @@
+package analytics
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestProductJSON(t *testing.T) {
+	p := Product{
+		ID:       "507f1f77bcf86cd799439011",
+		SKU:      "G-32",
+		Name:     "Monopoly",
+		Price:    18.99,
+		Quantity: 1,
+		Brand:    "Hasbro",
+		Variant:  "200 pieces",
+		Category: "Games",
+		Coupon:   "MAYDEALS",
+		Position: 3,
+		URL:      "https://www.example.com/product/path",
+		ImageURL: "https://www.example.com/product/path.jpg",
+	}
+
+	const ref = `{"id":"507f1f77bcf86cd799439011","sku":"G-32","name":"Monopoly","price":18.99,"quantity":1,"brand":"Hasbro","variant":"200 pieces","category":"Games","coupon":"MAYDEALS","position":3,"url":"https://www.example.com/product/path","image_url":"https://www.example.com/product/path.jpg"}`
+
+	if b, err := json.Marshal(p); err != nil {
+		t.Error("marshalling product failed:", err)
+	} else if s := string(b); s != ref {
+		t.Error("invalid marshaled representation of product:", s)
+	}
+}
+
+func TestProductValidate(t *testing.T) {
+	if err := (Product{Name: "A"}).Validate(); err != (FieldError{
+		Type:  "analytics.Product",
+		Name:  "ID",
+		Value: "",
+	}) {
+		t.Error("invalid error returned when validating a product without id:", err)
+	}
+
+	if err := (Product{SKU: "A"}).Validate(); err != nil {
+		t.Error("validating a product with a sku failed:", err)
+	}
+}
+
+func TestEcommerceEvents(t *testing.T) {
+	product := Product{ID: "1", Name: "A", Price: 42}
+	order := Order{OrderId: "2", Total: 42, Currency: "USD", Products: []Product{product}}
+
+	tests := map[string]struct {
+		run   func() (Track, error)
+		event string
+		props Properties
+	}{
+		"products searched": {
+			func() (Track, error) { return NewProductsSearched("blue hotpants") },
+			EventProductsSearched,
+			Properties{"query": "blue hotpants"},
+		},
+		"product list viewed": {
+			func() (Track, error) {
+				return NewProductListViewed(ProductList{ListId: "hot", Products: []Product{product}})
+			},
+			EventProductListViewed,
+			Properties{"list_id": "hot", "products": []Product{product}},
+		},
+		"product viewed": {
+			func() (Track, error) { return NewProductViewed(product) },
+			EventProductViewed,
+			Properties{"product_id": "1", "name": "A", "price": 42.0},
+		},
+		"product added": {
+			func() (Track, error) { return NewProductAdded("3", product) },
+			EventProductAdded,
+			Properties{"cart_id": "3", "product_id": "1", "name": "A", "price": 42.0},
+		},
+		"cart viewed": {
+			func() (Track, error) { return NewCartViewed(Cart{CartId: "3", Products: []Product{product}}) },
+			EventCartViewed,
+			Properties{"cart_id": "3", "products": []Product{product}},
+		},
+		"checkout started": {
+			func() (Track, error) { return NewCheckoutStarted(order) },
+			EventCheckoutStarted,
+			Properties{"order_id": "2", "total": 42.0, "currency": "USD", "products": []Product{product}},
+		},
+		"order completed": {
+			func() (Track, error) { return NewOrderCompleted(order) },
+			EventOrderCompleted,
+			Properties{"order_id": "2", "total": 42.0, "currency": "USD", "products": []Product{product}},
+		},
+		"order refunded": {
+			func() (Track, error) { return NewOrderRefunded(Order{OrderId: "2"}) },
+			EventOrderRefunded,
+			Properties{"order_id": "2"},
+		},
+		"coupon applied": {
+			func() (Track, error) { return NewCouponApplied(Coupon{CouponId: "4", Discount: 5}) },
+			EventCouponApplied,
+			Properties{"coupon_id": "4", "discount": 5.0},
+		},
+	}
+
+	for name, test := range tests {
+		track, err := test.run()
+		if err != nil {
+			t.Errorf("%s: building the event failed: %s", name, err)
+			continue
+		}
+
+		if track.Event != test.event {
+			t.Errorf("%s: invalid event name: %s", name, track.Event)
+		}
+
+		if !reflect.DeepEqual(track.Properties, test.props) {
+			t.Errorf("%s: invalid properties produced:\n- expected %#v\n- found: %#v", name, test.props, track.Properties)
+		}
+	}
+}
+
+func TestEcommerceEventsMissingProperties(t *testing.T) {
+	tests := map[string]struct {
+		run func() (Track, error)
+		err FieldError
+	}{
+		"products searched": {
+			func() (Track, error) { return NewProductsSearched("") },
+			FieldError{Type: "analytics.ProductsSearched", Name: "Query", Value: ""},
+		},
+		"product viewed": {
+			func() (Track, error) { return NewProductViewed(Product{Name: "A"}) },
+			FieldError{Type: "analytics.Product", Name: "ID", Value: ""},
+		},
+		"cart viewed": {
+			func() (Track, error) { return NewCartViewed(Cart{CartId: "3"}) },
+			FieldError{Type: "analytics.CartViewed", Name: "Products", Value: 0},
+		},
+		"order completed": {
+			func() (Track, error) { return NewOrderCompleted(Order{Total: 42}) },
+			FieldError{Type: "analytics.OrderCompleted", Name: "OrderId", Value: ""},
+		},
+		"order products": {
+			func() (Track, error) { return NewOrderCancelled(Order{OrderId: "2", Products: []Product{{}}}) },
+			FieldError{Type: "analytics.Product", Name: "ID", Value: ""},
+		},
+		"coupon applied": {
+			func() (Track, error) { return NewCouponApplied(Coupon{OrderId: "2"}) },
+			FieldError{Type: "analytics.CouponApplied", Name: "CouponId", Value: ""},
+		},
+	}
+
+	for name, test := range tests {
+		if _, err := test.run(); err != test.err {
+			t.Errorf("%s: invalid error returned: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,657 @@
+package analytics
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// This file contains a hand-written JSON encoder for the message types and the
+// context, it produces the exact same output as encoding/json (HTML escaping,
+// sorted map keys, omitempty rules...) without going through reflection.
+// Values that the encoder doesn't know about, like custom types set in the
+// properties of a message, are delegated to encoding/json.
+
+// Buffers larger than this are not returned to the pool, so a few very large
+// messages don't keep memory allocated forever.
+const maxPooledBufferSize = 64 * 1024
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		return &encoder{b: make([]byte, 0, 1024)}
+	},
+}
+
+type encoder struct {
+	b   []byte
+	err error
+
+	// Scratch space used to sort the keys of maps, nested maps push their keys
+	// after the ones of their parents.
+	keys []string
+
+	// The position of the sentAt value of the last message encoded.
+	sentAt, sentAtEnd int
+}
+
+func getEncoder() *encoder {
+	e := encoderPool.Get().(*encoder)
+	e.b, e.err, e.keys = e.b[:0], nil, e.keys[:0]
+	e.sentAt, e.sentAtEnd = 0, 0
+	return e
+}
+
+func putEncoder(e *encoder) {
+	if cap(e.b) <= maxPooledBufferSize {
+		encoderPool.Put(e)
+	}
+}
+
+// Returns a copy of the encoded bytes, so the encoder can be put back in the
+// pool.
+func (e *encoder) bytes() ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	b := make([]byte, len(e.b))
+	copy(b, e.b)
+	return b, nil
+}
+
+func (e *encoder) message(msg Message) {
+	switch m := msg.(type) {
+	case Alias:
+		e.alias(&m)
+	case Group:
+		e.group(&m)
+	case Identify:
+		e.identify(&m)
+	case Page:
+		e.page(&m)
+	case Screen:
+		e.screen(&m)
+	case Track:
+		e.track(&m)
+	default:
+		e.fallback(msg)
+	}
+}
+
+func (e *encoder) alias(m *Alias) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("previousId", m.PreviousId, false)
+	e.stringField("userId", m.UserId, false)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) group(m *Group) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("anonymousId", m.AnonymousId, true)
+	e.stringField("userId", m.UserId, true)
+	e.stringField("groupId", m.GroupId, false)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("traits", m.Traits)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) identify(m *Identify) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("anonymousId", m.AnonymousId, true)
+	e.stringField("userId", m.UserId, true)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("traits", m.Traits)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) page(m *Page) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("anonymousId", m.AnonymousId, true)
+	e.stringField("userId", m.UserId, true)
+	e.stringField("name", m.Name, true)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("properties", m.Properties)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) screen(m *Screen) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("anonymousId", m.AnonymousId, true)
+	e.stringField("userId", m.UserId, true)
+	e.stringField("name", m.Name, true)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("properties", m.Properties)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) track(m *Track) {
+	e.b = append(e.b, '{')
+	e.stringField("type", m.Type, true)
+	e.stringField("messageId", m.MessageId, true)
+	e.stringField("anonymousId", m.AnonymousId, true)
+	e.stringField("userId", m.UserId, true)
+	e.stringField("event", m.Event, false)
+	e.timeField("originalTimestamp", m.OriginalTimestamp)
+	e.sentAtField(m.SentAt)
+	e.contextField(m.Context)
+	e.mapField("properties", m.Properties)
+	e.mapField("integrations", m.Integrations)
+	e.stringField("channel", m.Channel, true)
+	e.b = append(e.b, '}')
+}
+
+// Appends the key of an object field, preceded by a comma unless it's the first
+// field of the object. Keys must not need to be escaped.
+func (e *encoder) key(k string) {
+	if e.b[len(e.b)-1] != '{' {
+		e.b = append(e.b, ',')
+	}
+	e.b = append(e.b, '"')
+	e.b = append(e.b, k...)
+	e.b = append(e.b, '"', ':')
+}
+
+func (e *encoder) stringField(k string, s string, omitempty bool) {
+	if !omitempty || len(s) != 0 {
+		e.key(k)
+		e.string(s)
+	}
+}
+
+func (e *encoder) boolField(k string, v bool) {
+	if v {
+		e.key(k)
+		e.b = append(e.b, "true"...)
+	}
+}
+
+func (e *encoder) intField(k string, v int) {
+	if v != 0 {
+		e.key(k)
+		e.b = strconv.AppendInt(e.b, int64(v), 10)
+	}
+}
+
+func (e *encoder) floatField(k string, v float64) {
+	if v != 0 {
+		e.key(k)
+		e.float(v, 64)
+	}
+}
+
+// Time values are never omitted, encoding/json doesn't consider structs to be
+// empty.
+func (e *encoder) timeField(k string, t time.Time) {
+	e.key(k)
+	e.time(t)
+}
+
+// Same as timeField but records the position of the value, so it can be
+// patched when the message is sent.
+func (e *encoder) sentAtField(t time.Time) {
+	e.key("sentAt")
+	e.sentAt = len(e.b)
+	e.time(t)
+	e.sentAtEnd = len(e.b)
+}
+
+func (e *encoder) mapField(k string, m map[string]interface{}) {
+	if len(m) != 0 {
+		e.key(k)
+		e.object(m)
+	}
+}
+
+func (e *encoder) contextField(ctx *Context) {
+	if ctx != nil {
+		e.key("context")
+		e.context(ctx)
+	}
+}
+
+// The fields of the context, sorted by key since the context is serialized as
+// a map.
+var contextFields = [...]struct {
+	key     string
+	present func(*Context) bool
+	encode  func(*encoder, *Context)
+}{
+	{"app", func(c *Context) bool { return c.App != AppInfo{} }, func(e *encoder, c *Context) { e.app(&c.App) }},
+	{"campaign", func(c *Context) bool { return c.Campaign != CampaignInfo{} }, func(e *encoder, c *Context) { e.campaign(&c.Campaign) }},
+	{"device", func(c *Context) bool { return c.Device != DeviceInfo{} }, func(e *encoder, c *Context) { e.device(&c.Device) }},
+	{"direct", func(c *Context) bool { return c.Direct }, func(e *encoder, c *Context) { e.b = append(e.b, "true"...) }},
+	{"ip", func(c *Context) bool { return len(c.IP) != 0 }, func(e *encoder, c *Context) { e.ip(c) }},
+	{"library", func(c *Context) bool { return c.Library != LibraryInfo{} }, func(e *encoder, c *Context) { e.library(&c.Library) }},
+	{"locale", func(c *Context) bool { return len(c.Locale) != 0 }, func(e *encoder, c *Context) { e.string(c.Locale) }},
+	{"location", func(c *Context) bool { return !isZeroLocation(&c.Location) }, func(e *encoder, c *Context) { e.location(&c.Location) }},
+	{"network", func(c *Context) bool { return c.Network != NetworkInfo{} }, func(e *encoder, c *Context) { e.network(&c.Network) }},
+	{"os", func(c *Context) bool { return c.OS != OSInfo{} }, func(e *encoder, c *Context) { e.os(&c.OS) }},
+	{"page", func(c *Context) bool { return c.Page != PageInfo{} }, func(e *encoder, c *Context) { e.pageInfo(&c.Page) }},
+	{"referrer", func(c *Context) bool { return c.Referrer != ReferrerInfo{} }, func(e *encoder, c *Context) { e.referrer(&c.Referrer) }},
+	{"screen", func(c *Context) bool { return c.Screen != ScreenInfo{} }, func(e *encoder, c *Context) { e.screenInfo(&c.Screen) }},
+	{"timezone", func(c *Context) bool { return len(c.Timezone) != 0 }, func(e *encoder, c *Context) { e.string(c.Timezone) }},
+	{"traits", func(c *Context) bool { return len(c.Traits) != 0 }, func(e *encoder, c *Context) { e.object(c.Traits) }},
+	{"userAgent", func(c *Context) bool { return len(c.UserAgent) != 0 }, func(e *encoder, c *Context) { e.string(c.UserAgent) }},
+}
+
+var contextFieldIndex = func() map[string]int {
+	m := make(map[string]int, len(contextFields))
+	for i, f := range contextFields {
+		m[f.key] = i
+	}
+	return m
+}()
+
+// Encodes the context the way `Context.MarshalJSON` is specified, the fields
+// of the context and the extra fields are merged in a single object with sorted
+// keys, the former taking precedence.
+func (e *encoder) context(c *Context) {
+	start := len(e.keys)
+	defer func() { e.keys = e.keys[:start] }()
+
+	for k := range c.Extra {
+		if i, found := contextFieldIndex[k]; !found || !contextFields[i].present(c) {
+			e.keys = append(e.keys, k)
+		}
+	}
+
+	extra := e.keys[start:]
+	sort.Strings(extra)
+
+	e.b = append(e.b, '{')
+
+	for i, j := 0, 0; i != len(contextFields) || j != len(extra); {
+		if i != len(contextFields) && (j == len(extra) || contextFields[i].key < extra[j]) {
+			if f := &contextFields[i]; f.present(c) {
+				e.key(f.key)
+				f.encode(e, c)
+			}
+			i++
+		} else {
+			e.mapKey(extra[j])
+			e.value(c.Extra[extra[j]])
+			j++
+		}
+	}
+
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) app(v *AppInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("name", v.Name, true)
+	e.stringField("version", v.Version, true)
+	e.stringField("build", v.Build, true)
+	e.stringField("namespace", v.Namespace, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) campaign(v *CampaignInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("name", v.Name, true)
+	e.stringField("source", v.Source, true)
+	e.stringField("medium", v.Medium, true)
+	e.stringField("term", v.Term, true)
+	e.stringField("content", v.Content, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) device(v *DeviceInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("id", v.Id, true)
+	e.stringField("manufacturer", v.Manufacturer, true)
+	e.stringField("model", v.Model, true)
+	e.stringField("name", v.Name, true)
+	e.stringField("type", v.Type, true)
+	e.stringField("version", v.Version, true)
+	e.stringField("advertisingId", v.AdvertisingID, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) library(v *LibraryInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("name", v.Name, true)
+	e.stringField("version", v.Version, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) location(v *LocationInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("city", v.City, true)
+	e.stringField("country", v.Country, true)
+	e.stringField("region", v.Region, true)
+	e.floatField("latitude", v.Latitude)
+	e.floatField("longitude", v.Longitude)
+	e.floatField("speed", v.Speed)
+	e.b = append(e.b, '}')
+}
+
+// The location can't be compared to its zero-value because of floats, -0 is
+// considered empty as well.
+func isZeroLocation(v *LocationInfo) bool {
+	return len(v.City) == 0 && len(v.Country) == 0 && len(v.Region) == 0 &&
+		v.Latitude == 0 && v.Longitude == 0 && v.Speed == 0
+}
+
+func (e *encoder) network(v *NetworkInfo) {
+	e.b = append(e.b, '{')
+	e.boolField("bluetooth", v.Bluetooth)
+	e.boolField("cellular", v.Cellular)
+	e.boolField("wifi", v.WIFI)
+	e.stringField("carrier", v.Carrier, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) os(v *OSInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("name", v.Name, true)
+	e.stringField("version", v.Version, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) pageInfo(v *PageInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("hash", v.Hash, true)
+	e.stringField("path", v.Path, true)
+	e.stringField("referrer", v.Referrer, true)
+	e.stringField("search", v.Search, true)
+	e.stringField("title", v.Title, true)
+	e.stringField("url", v.URL, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) referrer(v *ReferrerInfo) {
+	e.b = append(e.b, '{')
+	e.stringField("type", v.Type, true)
+	e.stringField("name", v.Name, true)
+	e.stringField("url", v.URL, true)
+	e.stringField("link", v.Link, true)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) screenInfo(v *ScreenInfo) {
+	e.b = append(e.b, '{')
+	e.intField("density", v.Density)
+	e.intField("width", v.Width)
+	e.intField("height", v.Height)
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) ip(c *Context) {
+	if n := len(c.IP); n != 4 && n != 16 {
+		// Let encoding/json report the error of invalid addresses.
+		e.fallback(c.IP)
+		return
+	}
+	e.b = append(e.b, '"')
+	e.b = append(e.b, c.IP.String()...)
+	e.b = append(e.b, '"')
+}
+
+// Appends a map key, preceded by a comma unless it's the first key of the
+// object. Unlike `key`, the key is escaped.
+func (e *encoder) mapKey(k string) {
+	if e.b[len(e.b)-1] != '{' {
+		e.b = append(e.b, ',')
+	}
+	e.string(k)
+	e.b = append(e.b, ':')
+}
+
+func (e *encoder) object(m map[string]interface{}) {
+	if m == nil {
+		e.b = append(e.b, "null"...)
+		return
+	}
+
+	start := len(e.keys)
+	defer func() { e.keys = e.keys[:start] }()
+
+	for k := range m {
+		e.keys = append(e.keys, k)
+	}
+
+	keys := e.keys[start:]
+	sort.Strings(keys)
+
+	e.b = append(e.b, '{')
+	for _, k := range keys {
+		e.mapKey(k)
+		e.value(m[k])
+	}
+	e.b = append(e.b, '}')
+}
+
+func (e *encoder) value(v interface{}) {
+	switch x := v.(type) {
+	case nil:
+		e.b = append(e.b, "null"...)
+	case string:
+		e.string(x)
+	case bool:
+		e.b = strconv.AppendBool(e.b, x)
+	case int:
+		e.b = strconv.AppendInt(e.b, int64(x), 10)
+	case int8:
+		e.b = strconv.AppendInt(e.b, int64(x), 10)
+	case int16:
+		e.b = strconv.AppendInt(e.b, int64(x), 10)
+	case int32:
+		e.b = strconv.AppendInt(e.b, int64(x), 10)
+	case int64:
+		e.b = strconv.AppendInt(e.b, x, 10)
+	case uint:
+		e.b = strconv.AppendUint(e.b, uint64(x), 10)
+	case uint8:
+		e.b = strconv.AppendUint(e.b, uint64(x), 10)
+	case uint16:
+		e.b = strconv.AppendUint(e.b, uint64(x), 10)
+	case uint32:
+		e.b = strconv.AppendUint(e.b, uint64(x), 10)
+	case uint64:
+		e.b = strconv.AppendUint(e.b, x, 10)
+	case float32:
+		e.float(float64(x), 32)
+	case float64:
+		e.float(x, 64)
+	case time.Time:
+		e.time(x)
+	case map[string]interface{}:
+		e.object(x)
+	case Properties:
+		e.object(x)
+	case Traits:
+		e.object(x)
+	case Integrations:
+		e.object(x)
+	case []interface{}:
+		if x == nil {
+			e.b = append(e.b, "null"...)
+			return
+		}
+		e.b = append(e.b, '[')
+		for i, item := range x {
+			if i != 0 {
+				e.b = append(e.b, ',')
+			}
+			e.value(item)
+		}
+		e.b = append(e.b, ']')
+	case []string:
+		if x == nil {
+			e.b = append(e.b, "null"...)
+			return
+		}
+		e.b = append(e.b, '[')
+		for i, item := range x {
+			if i != 0 {
+				e.b = append(e.b, ',')
+			}
+			e.string(item)
+		}
+		e.b = append(e.b, ']')
+	default:
+		e.fallback(v)
+	}
+}
+
+// Formats floats the way encoding/json does.
+func (e *encoder) float(f float64, bits int) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		// Let encoding/json report the error of unsupported values.
+		if bits == 32 {
+			e.fallback(float32(f))
+		} else {
+			e.fallback(f)
+		}
+		return
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+
+	e.b = strconv.AppendFloat(e.b, f, format, -1, bits)
+
+	if format == 'e' {
+		// Clean up e-09 to e-9.
+		if n := len(e.b); n >= 4 && e.b[n-4] == 'e' && e.b[n-3] == '-' && e.b[n-2] == '0' {
+			e.b[n-2] = e.b[n-1]
+			e.b = e.b[:n-1]
+		}
+	}
+}
+
+func (e *encoder) time(t time.Time) {
+	_, offset := t.Zone()
+
+	if y := t.Year(); y < 0 || y > 9999 || offset <= -24*3600 || offset >= 24*3600 {
+		// Let encoding/json report the error of times that can't be formatted
+		// as RFC 3339.
+		e.fallback(t)
+		return
+	}
+
+	e.b = append(e.b, '"')
+	e.b = t.AppendFormat(e.b, time.RFC3339Nano)
+	e.b = append(e.b, '"')
+}
+
+const hex = "0123456789abcdef"
+
+// Appends a quoted string, escaped the way encoding/json does it with HTML
+// escaping enabled.
+func (e *encoder) string(s string) {
+	start := len(e.b)
+	e.b = append(e.b, '"')
+
+	i, last := 0, 0
+	for i < len(s) {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+
+			e.b = append(e.b, s[last:i]...)
+
+			switch c {
+			case '"', '\\':
+				e.b = append(e.b, '\\', c)
+			case '\n':
+				e.b = append(e.b, '\\', 'n')
+			case '\r':
+				e.b = append(e.b, '\\', 'r')
+			case '\t':
+				e.b = append(e.b, '\\', 't')
+			case '\b', '\f':
+				// The escaping of these characters depends on the version of
+				// encoding/json.
+				e.b = e.b[:start]
+				e.fallback(s)
+				return
+			default:
+				e.b = append(e.b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+
+			i++
+			last = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+
+		if r == utf8.RuneError && size == 1 {
+			e.b = append(e.b, s[last:i]...)
+			e.b = append(e.b, "\ufffd"...)
+			i += size
+			last = i
+			continue
+		}
+
+		if r == '\u2028' || r == '\u2029' {
+			e.b = append(e.b, s[last:i]...)
+			e.b = append(e.b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			last = i
+			continue
+		}
+
+		i += size
+	}
+
+	e.b = append(e.b, s[last:]...)
+	e.b = append(e.b, '"')
+}
+
+// Encodes values unknown to the encoder with encoding/json.
+func (e *encoder) fallback(v interface{}) {
+	if e.err != nil {
+		return
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		e.err = err
+		return
+	}
+
+	e.b = append(e.b, b...)
+}
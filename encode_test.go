@@ -0,0 +1,277 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Encodes a message to JSON with the encoder, the output is compared to the
+// output of json.Marshal.
+func marshalMessage(msg Message) ([]byte, error) {
+	e := getEncoder()
+	defer putEncoder(e)
+	e.message(msg)
+	return e.bytes()
+}
+
+// The reflection based implementation of `Context.MarshalJSON` that the
+// encoder replaced, it is used as reference.
+func marshalContextReflect(ctx Context) ([]byte, error) {
+	v := reflect.ValueOf(ctx)
+	m := make(map[string]interface{}, v.NumField()+len(ctx.Extra))
+
+	for name, value := range ctx.Extra {
+		m[name] = value
+	}
+
+	return json.Marshal(structToMap(v, m))
+}
+
+type reflectContext Context
+
+func (ctx reflectContext) MarshalJSON() ([]byte, error) {
+	return marshalContextReflect(Context(ctx))
+}
+
+// Same as Track but encoded entirely through reflection, it is used as
+// reference in benchmarks.
+type reflectTrack struct {
+	Type              string          `json:"type,omitempty"`
+	MessageId         string          `json:"messageId,omitempty"`
+	AnonymousId       string          `json:"anonymousId,omitempty"`
+	UserId            string          `json:"userId,omitempty"`
+	Event             string          `json:"event"`
+	OriginalTimestamp time.Time       `json:"originalTimestamp,omitempty"`
+	SentAt            time.Time       `json:"sentAt,omitempty"`
+	Context           *reflectContext `json:"context,omitempty"`
+	Properties        Properties      `json:"properties,omitempty"`
+	Integrations      Integrations    `json:"integrations,omitempty"`
+	Channel           string          `json:"channel,omitempty"`
+}
+
+type testStruct struct {
+	A string `json:"a"`
+	B int    `json:"b,omitempty"`
+}
+
+func testEncodingContext() *Context {
+	return &Context{
+		App:       AppInfo{Name: "app", Version: "1.0"},
+		Campaign:  CampaignInfo{Name: "summer", Source: "newsletter"},
+		Device:    DeviceInfo{Id: "device", Type: "mobile", AdvertisingID: "ad"},
+		Library:   LibraryInfo{Name: "analytics-go", Version: Version},
+		Location:  LocationInfo{Latitude: 48.8566, Longitude: 2.3522},
+		Network:   NetworkInfo{WIFI: true, Carrier: "carrier"},
+		OS:        OSInfo{Name: "linux"},
+		Page:      PageInfo{Path: "/", URL: "https://example.com/?a=1&b=<2>"},
+		Referrer:  ReferrerInfo{Type: "search"},
+		Screen:    ScreenInfo{Width: 1920, Height: 1080},
+		IP:        net.IPv4(127, 0, 0, 1),
+		Direct:    true,
+		Locale:    "en-US",
+		Timezone:  "Europe/Paris",
+		UserAgent: "Mozilla/5.0",
+		Traits:    Traits{"email": "user@example.com", "age": 42},
+		Extra: map[string]interface{}{
+			"app":       "overridden",
+			"zzz":       []interface{}{1, "two", 3.5},
+			"aaa":       map[string]interface{}{"b": 1, "a": nil},
+			"userAgent": "overridden",
+		},
+	}
+}
+
+func TestEncodeContext(t *testing.T) {
+	tests := map[string]Context{
+		"zero":  {},
+		"full":  *testEncodingContext(),
+		"ipv6":  {IP: net.ParseIP("2001:db8::1")},
+		"extra": {Extra: map[string]interface{}{"locale": "fr", "os": OSInfo{Name: "extra"}}},
+		"speed": {Location: LocationInfo{Speed: -1e-7}},
+		"traits": {Traits: Traits{
+			"nested": Traits{"b": true, "a": []string{"x", "<y>"}},
+			"nil":    []string(nil),
+		}},
+	}
+
+	for name, ctx := range tests {
+		t.Run(name, func(t *testing.T) {
+			ref, err := marshalContextReflect(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ctx.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, ref) {
+				t.Errorf("invalid context encoding:\n- expected: %s\n- found:    %s", ref, b)
+			}
+		})
+	}
+}
+
+func TestEncodeMessages(t *testing.T) {
+	ts := time.Date(2015, time.July, 10, 23, 0, 0, 123456789, time.FixedZone("IST", 5*3600+1800))
+	ctx := testEncodingContext()
+
+	tests := map[string]Message{
+		"alias":       Alias{Type: "alias", PreviousId: "A", UserId: "B", OriginalTimestamp: ts, Context: ctx},
+		"alias-empty": Alias{},
+		"group": Group{
+			Type: "group", GroupId: "A", UserId: "B", SentAt: ts,
+			Traits:       Traits{"name": "group"},
+			Integrations: NewIntegrations().DisableAll().Enable("Amplitude"),
+		},
+		"identify": Identify{Type: "identify", UserId: "B", Traits: NewTraits().SetEmail("a&b@example.com")},
+		"page":     Page{Type: "page", Name: "Home", AnonymousId: "C", Properties: NewProperties().SetURL("https://example.com/")},
+		"screen":   Screen{Type: "screen", Name: "Main", UserId: "B", Properties: Properties{}},
+		"track": Track{
+			Type:      "track",
+			MessageId: "1",
+			UserId:    "B",
+			Event:     "Order <Completed>",
+			Context:   ctx,
+			Channel:   "server",
+			Properties: Properties{
+				"strings":  []interface{}{"\u2028\u2029", "\x00\x1f\x7f", "\b\f", "\xff", "héllo 日本", "\"\\"},
+				"floats":   []interface{}{0.0, math.Copysign(0, -1), 1e-7, 1e21, 123.456, float32(0.1), 1e20},
+				"ints":     []interface{}{int8(-1), uint16(2), int64(math.MaxInt64), uint64(math.MaxUint64)},
+				"times":    []interface{}{time.Time{}, ts, ts.UTC()},
+				"custom":   testStruct{A: "a"},
+				"pointer":  &testStruct{B: 1},
+				"number":   json.Number("1.5"),
+				"strmap":   map[string]string{"b": "1", "a": "2"},
+				"nested":   Properties{"list": []string{}, "map": map[string]interface{}(nil)},
+				"bytes":    []byte("bytes"),
+				"duration": time.Second,
+			},
+		},
+	}
+
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			ref, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := marshalMessage(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(b, ref) {
+				t.Errorf("invalid message encoding:\n- expected: %s\n- found:    %s", ref, b)
+			}
+		})
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := map[string]interface{}{
+		"nan":  math.NaN(),
+		"inf":  float32(math.Inf(1)),
+		"time": time.Date(10000, time.January, 1, 0, 0, 0, 0, time.UTC),
+		"func": func() {},
+	}
+
+	for name, value := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := marshalMessage(Track{Event: "A", Properties: Properties{"value": value}}); err == nil {
+				t.Error("encoding an invalid value should fail")
+			}
+		})
+	}
+}
+
+func benchmarkTrack() Track {
+	return Track{
+		Type:              "track",
+		MessageId:         "2b5a5e1c-4b7c-4bd8-9ee6-5f9f1bf4c9d2",
+		AnonymousId:       "7e6a9c2f-21cb-4c4e-8b8d-0c1e7a4f8a65",
+		UserId:            "user",
+		Event:             "Order Completed",
+		OriginalTimestamp: time.Date(2015, time.July, 10, 23, 0, 0, 0, time.UTC),
+		SentAt:            time.Date(2015, time.July, 10, 23, 0, 1, 0, time.UTC),
+		Context:           testEncodingContext(),
+		Properties: Properties{
+			"order_id": "42",
+			"total":    27.5,
+			"currency": "USD",
+			"products": []interface{}{
+				map[string]interface{}{"product_id": "1", "price": 10.5, "quantity": 1},
+				map[string]interface{}{"product_id": "2", "price": 17, "quantity": 2},
+			},
+		},
+		Channel: "server",
+	}
+}
+
+func BenchmarkMarshalTrack(b *testing.B) {
+	track := benchmarkTrack()
+
+	b.Run("reflection", func(b *testing.B) {
+		ctx := reflectContext(*track.Context)
+		msg := reflectTrack{
+			Type:              track.Type,
+			MessageId:         track.MessageId,
+			AnonymousId:       track.AnonymousId,
+			UserId:            track.UserId,
+			Event:             track.Event,
+			OriginalTimestamp: track.OriginalTimestamp,
+			SentAt:            track.SentAt,
+			Context:           &ctx,
+			Properties:        track.Properties,
+			Channel:           track.Channel,
+		}
+		b.ReportAllocs()
+
+		for i := 0; i != b.N; i++ {
+			if _, err := json.Marshal(msg); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i != b.N; i++ {
+			if _, err := marshalMessage(track); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkMarshalContext(b *testing.B) {
+	ctx := *testEncodingContext()
+
+	b.Run("reflection", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i != b.N; i++ {
+			if _, err := marshalContextReflect(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("encoder", func(b *testing.B) {
+		b.ReportAllocs()
+
+		for i := 0; i != b.N; i++ {
+			if _, err := ctx.MarshalJSON(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package analytics
+
+// Values implementing this interface are used by clients to add information to
+// the context of messages before they are queued, enrichers are configured by
+// setting the `Enrichers` field of the client configuration.
+//
+// Enrichers are called synchronously by `Enqueue`, on the goroutine of the
+// application, so they must return quickly. They should only fill fields that
+// were left empty by the application.
+type Enricher interface {
+	Enrich(*Context)
+}
+
+// This type adapts a function to the Enricher interface.
+type EnricherFunc func(*Context)
+
+func (f EnricherFunc) Enrich(ctx *Context) { f(ctx) }
@@ -0,0 +1,42 @@
+package analytics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClientEnrichers(t *testing.T) {
+	body, server := mockServer()
+	defer server.Close()
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl: server.URL,
+		Logger:       testLogger{t.Logf, t.Logf},
+		BatchSize:    1,
+		Enrichers: []Enricher{
+			EnricherFunc(func(ctx *Context) { ctx.Timezone = "Europe/Paris" }),
+			EnricherFunc(func(ctx *Context) { ctx.Locale = ctx.Timezone }),
+		},
+	})
+	defer client.Close()
+
+	ctx := &Context{Locale: "fr-FR"}
+	client.Enqueue(Track{UserId: "A", Event: "B", Context: ctx})
+
+	var res struct {
+		Batch []struct {
+			Context Context `json:"context"`
+		} `json:"batch"`
+	}
+	if err := json.Unmarshal(<-body, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx := res.Batch[0].Context; ctx.Timezone != "Europe/Paris" || ctx.Locale != "Europe/Paris" {
+		t.Errorf("the enrichers were not applied in order: %#v", ctx)
+	}
+
+	if ctx.Timezone != "" || ctx.Locale != "fr-FR" || ctx.Library.Name != "" {
+		t.Errorf("the context of the application was modified: %#v", ctx)
+	}
+}
@@ -3,6 +3,7 @@ package analytics
 import (
 	"errors"
 	"fmt"
+	"strconv"
 )
 
 // Returned by the `NewWithConfig` function when the one of the configuration
@@ -44,6 +45,19 @@ func (e FieldError) Error() string {
 	return fmt.Sprintf("%s.%s: invalid field value: %#v", e.Type, e.Name, e.Value)
 }
 
+// Returned by uploads when the data plane responded with an error status.
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e statusError) Error() string {
+	if len(e.status) == 0 {
+		return strconv.Itoa(e.code)
+	}
+	return fmt.Sprintf("%d %s", e.code, e.status)
+}
+
 var (
 	// This error is returned by methods of the `Client` interface when they are
 	// called after the client was already closed.
@@ -57,4 +71,37 @@ var (
 	// failed because the JSON representation of a message exceeded the upper
 	// limit.
 	ErrMessageTooBig = errors.New("the message exceeds the maximum allowed size")
+
+	// This error is returned by the `Enqueue` method when the message belongs
+	// to a user that was suppressed by a regulation, the message is dropped.
+	ErrUserSuppressed = errors.New("the message belongs to a suppressed user")
+
+	// This error is returned by the `Enqueue` method when deduplication is
+	// enabled and a message with the same id was already queued within the
+	// deduplication window, the message is dropped.
+	ErrDuplicateMessage = errors.New("a message with the same id was already queued")
+
+	// This error is returned by the `Enqueue` method when the message was
+	// dropped because its user is not part of the sample selected by the
+	// sampler of the client.
+	ErrSampled = errors.New("the message was dropped by the sampler")
+
+	// This error is returned by the `Enqueue` method when the message was
+	// dropped because the rate limit set on the sampler of the client was
+	// reached.
+	ErrRateLimited = errors.New("the message was dropped by the rate limiter")
+
+	// This error is returned by multi clients when the queue of one of their
+	// endpoints is full, the message is dropped for this endpoint.
+	ErrQueueFull = errors.New("the message queue is full")
+
+	// This error is used to notify the client callbacks that messages were
+	// dropped because the circuit breaker was open and they couldn't be
+	// parked.
+	ErrCircuitOpen = errors.New("the circuit breaker is open")
+
+	// This error is used to notify the client callbacks that a batch sent with
+	// `ProtocolStream` failed because its stream ended before the batch was
+	// acknowledged, or because no acknowledgement was received in time.
+	ErrStreamClosed = errors.New("the stream ended before the batch was acknowledged")
 )
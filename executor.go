@@ -7,6 +7,19 @@ type executor struct {
 	mutex sync.Mutex
 	size  int
 	cap   int
+
+	// The number of goroutines that only high priority tasks may use.
+	reserved int
+
+	// When set the capacity of the executor follows the adaptive limit, and
+	// tasks exceeding it wait in the pending list until a goroutine is done.
+	adaptive *AdaptiveConcurrency
+	pending  []executorTask
+}
+
+type executorTask struct {
+	run      func()
+	priority Priority
 }
 
 func newExecutor(cap int) *executor {
@@ -18,19 +31,68 @@ func newExecutor(cap int) *executor {
 	return e
 }
 
-func (e *executor) do(task func()) (ok bool) {
+func (e *executor) do(task func()) bool {
+	return e.doWithPriority(task, PriorityNormal)
+}
+
+func (e *executor) doWithPriority(task func(), priority Priority) (ok bool) {
 	e.mutex.Lock()
 
-	if e.size != e.cap {
+	if e.size < e.limit(priority) {
 		e.queue <- task
 		e.size++
 		ok = true
+	} else if e.adaptive != nil && len(e.pending) < e.adaptive.queueSize() {
+		e.enqueue(executorTask{run: task, priority: priority})
+		ok = true
 	}
 
 	e.mutex.Unlock()
 	return
 }
 
+// Returns the number of tasks of the given priority that the executor would
+// accept right now.
+func (e *executor) free(priority Priority) int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	n := max(e.limit(priority)-e.size, 0)
+	if e.adaptive != nil {
+		n += max(e.adaptive.queueSize()-len(e.pending), 0)
+	}
+	return n
+}
+
+// Returns the number of goroutines that tasks of the given priority may use,
+// the method must be called with the mutex held.
+func (e *executor) limit(priority Priority) int {
+	limit := e.cap
+	if e.adaptive != nil {
+		limit = e.adaptive.Limit()
+	}
+
+	if priority == PriorityNormal {
+		// The reserved capacity shrinks along with the adaptive limit.
+		limit -= e.reserved * limit / e.cap
+	}
+	return limit
+}
+
+// Adds a task to the pending list, high priority tasks are placed ahead of
+// normal ones. The method must be called with the mutex held.
+func (e *executor) enqueue(task executorTask) {
+	i := len(e.pending)
+	if task.priority == PriorityHigh {
+		for i != 0 && e.pending[i-1].priority != PriorityHigh {
+			i--
+		}
+	}
+	e.pending = append(e.pending, executorTask{})
+	copy(e.pending[i+1:], e.pending[i:])
+	e.pending[i] = task
+}
+
 func (e *executor) close() {
 	close(e.queue)
 }
@@ -49,5 +111,16 @@ func (e *executor) run(task func()) {
 func (e *executor) done() {
 	e.mutex.Lock()
 	e.size--
+
+	// Pending tasks are started directly because the queue may have been
+	// closed already when the executor is being closed.
+	for len(e.pending) != 0 && e.size < e.limit(e.pending[0].priority) {
+		task := e.pending[0]
+		e.pending[0] = executorTask{}
+		e.pending = e.pending[1:]
+		e.size++
+		go e.run(task.run)
+	}
+
 	e.mutex.Unlock()
 }
@@ -54,3 +54,24 @@ func TestExecutorMulti(t *testing.T) {
 	// Make sure wg.Done gets called, this shouldn't block indefinitely.
 	wg.Wait()
 }
+
+func TestExecutorReserved(t *testing.T) {
+	ex := newExecutor(2)
+	ex.reserved = 1
+	defer ex.close()
+
+	block := make(chan struct{})
+	defer close(block)
+
+	if !ex.do(func() { <-block }) {
+		t.Fatal("failed pushing a normal task to an executor with available capacity")
+	}
+
+	if ex.do(func() {}) {
+		t.Error("a normal task was accepted on the reserved capacity")
+	}
+
+	if !ex.doWithPriority(func() { <-block }, PriorityHigh) {
+		t.Error("a high priority task was refused by an executor with reserved capacity")
+	}
+}
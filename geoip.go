@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"errors"
+	"io/fs"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// This type carries the configuration of enrichers created by
+// `NewGeoIPEnricher`.
+type GeoIPConfig struct {
+
+	// The path to a database file in the MaxMind DB format, for example the
+	// GeoLite2 or GeoIP2 City databases.
+	DatabasePath string
+
+	// The maximum number of IP addresses for which the result of the lookup is
+	// cached, set to `DefaultGeoIPCacheSize` by default.
+	CacheSize int
+
+	// The language of the city, region and country names, names are reported
+	// in English if none is set or if the database has no translation.
+	Language string
+}
+
+// This constant sets the default number of lookups cached by geolocation
+// enrichers.
+const DefaultGeoIPCacheSize = 10000
+
+// This enricher looks up the `IP` field of contexts in a local geolocation
+// database to fill the `Location` field, no network requests are made.
+// Contexts that already have a location are left untouched.
+type GeoIPEnricher struct {
+	db    *maxminddb.Reader
+	cache *lruCache
+	lang  string
+}
+
+// The subset of the records of City databases used to fill locations.
+type geoIPRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string            `maxminddb:"iso_code"`
+		Names   map[string]string `maxminddb:"names"`
+	} `maxminddb:"country"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+// Opens the database configured in the argument and returns an enricher using
+// it. If the database file doesn't exist the returned enricher does nothing,
+// so applications can ship the same configuration whether or not a database
+// was installed. An error is returned if the file exists but cannot be read, or
+// if the configuration contains impossible values.
+func NewGeoIPEnricher(config GeoIPConfig) (*GeoIPEnricher, error) {
+	if config.CacheSize < 0 {
+		return nil, FieldError{
+			Type:  "analytics.GeoIPConfig",
+			Name:  "CacheSize",
+			Value: config.CacheSize,
+		}
+	}
+
+	if config.CacheSize == 0 {
+		config.CacheSize = DefaultGeoIPCacheSize
+	}
+
+	if config.Language == "" {
+		config.Language = "en"
+	}
+
+	e := &GeoIPEnricher{
+		cache: newLRUCache(config.CacheSize),
+		lang:  config.Language,
+	}
+
+	db, err := maxminddb.Open(config.DatabasePath)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return e, nil
+	case err != nil:
+		return nil, err
+	}
+
+	e.db = db
+	return e, nil
+}
+
+func (e *GeoIPEnricher) Enrich(ctx *Context) {
+	if e.db == nil || ctx.IP == nil || ctx.Location != (LocationInfo{}) {
+		return
+	}
+
+	key := ctx.IP.String()
+
+	if loc, ok := e.cache.get(key); ok {
+		ctx.Location = loc.(LocationInfo)
+		return
+	}
+
+	var rec geoIPRecord
+	var loc LocationInfo
+
+	// Failed lookups are cached as empty locations as well, they would fail
+	// again the next time.
+	if err := e.db.Lookup(ctx.IP, &rec); err == nil {
+		loc = LocationInfo{
+			City:      e.name(rec.City.Names),
+			Country:   e.name(rec.Country.Names),
+			Latitude:  rec.Location.Latitude,
+			Longitude: rec.Location.Longitude,
+		}
+		if len(loc.Country) == 0 {
+			loc.Country = rec.Country.ISOCode
+		}
+		if len(rec.Subdivisions) != 0 {
+			loc.Region = e.name(rec.Subdivisions[0].Names)
+		}
+	}
+
+	e.cache.add(key, loc)
+	ctx.Location = loc
+}
+
+// Close releases the database used by the enricher.
+func (e *GeoIPEnricher) Close() error {
+	if e.db == nil {
+		return nil
+	}
+	return e.db.Close()
+}
+
+func (e *GeoIPEnricher) name(names map[string]string) string {
+	if name, ok := names[e.lang]; ok {
+		return name
+	}
+	return names["en"]
+}
@@ -0,0 +1,122 @@
+package analytics
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGeoIPEnricher(t *testing.T) {
+	e, err := NewGeoIPEnricher(GeoIPConfig{
+		DatabasePath: filepath.Join("fixtures", "test-geoip.mmdb"),
+	})
+	if err != nil {
+		t.Fatal("opening the database failed:", err)
+	}
+	defer e.Close()
+
+	ctx := &Context{IP: net.ParseIP("81.2.69.142")}
+	e.Enrich(ctx)
+
+	ref := LocationInfo{
+		City:      "London",
+		Country:   "United Kingdom",
+		Region:    "England",
+		Latitude:  51.5142,
+		Longitude: -0.0931,
+	}
+
+	if ctx.Location != ref {
+		t.Errorf("invalid location:\n- expected %#v\n- found: %#v", ref, ctx.Location)
+	}
+
+	if n := e.cache.len(); n != 1 {
+		t.Error("the lookup was not cached:", n)
+	}
+
+	// The second lookup is served by the cache.
+	ctx = &Context{IP: net.ParseIP("81.2.69.142")}
+	e.Enrich(ctx)
+
+	if ctx.Location != ref {
+		t.Errorf("invalid cached location: %#v", ctx.Location)
+	}
+}
+
+func TestGeoIPEnricherLanguage(t *testing.T) {
+	e, _ := NewGeoIPEnricher(GeoIPConfig{
+		DatabasePath: filepath.Join("fixtures", "test-geoip.mmdb"),
+		Language:     "fr",
+	})
+	defer e.Close()
+
+	ctx := &Context{IP: net.ParseIP("81.2.69.1")}
+	e.Enrich(ctx)
+
+	if ctx.Location.City != "Londres" || ctx.Location.Country != "Royaume-Uni" {
+		t.Errorf("invalid translated location: %#v", ctx.Location)
+	}
+}
+
+func TestGeoIPEnricherUnknownIP(t *testing.T) {
+	e, _ := NewGeoIPEnricher(GeoIPConfig{
+		DatabasePath: filepath.Join("fixtures", "test-geoip.mmdb"),
+	})
+	defer e.Close()
+
+	ctx := &Context{IP: net.ParseIP("127.0.0.1")}
+	e.Enrich(ctx)
+
+	if ctx.Location != (LocationInfo{}) {
+		t.Errorf("invalid location for an unknown address: %#v", ctx.Location)
+	}
+}
+
+func TestGeoIPEnricherKeepsLocation(t *testing.T) {
+	e, _ := NewGeoIPEnricher(GeoIPConfig{
+		DatabasePath: filepath.Join("fixtures", "test-geoip.mmdb"),
+	})
+	defer e.Close()
+
+	ctx := &Context{IP: net.ParseIP("81.2.69.142"), Location: LocationInfo{City: "Paris"}}
+	e.Enrich(ctx)
+
+	if ctx.Location != (LocationInfo{City: "Paris"}) {
+		t.Errorf("the enricher overwrote the location: %#v", ctx.Location)
+	}
+}
+
+func TestGeoIPEnricherMissingDatabase(t *testing.T) {
+	e, err := NewGeoIPEnricher(GeoIPConfig{
+		DatabasePath: filepath.Join(t.TempDir(), "missing.mmdb"),
+	})
+	if err != nil {
+		t.Fatal("a missing database should not be an error:", err)
+	}
+	defer e.Close()
+
+	ctx := &Context{IP: net.ParseIP("81.2.69.142")}
+	e.Enrich(ctx)
+
+	if ctx.Location != (LocationInfo{}) {
+		t.Errorf("invalid location set without a database: %#v", ctx.Location)
+	}
+}
+
+func TestGeoIPEnricherInvalidDatabase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invalid.mmdb")
+	os.WriteFile(path, []byte("not a database"), 0600)
+
+	if _, err := NewGeoIPEnricher(GeoIPConfig{DatabasePath: path}); err == nil {
+		t.Error("no error returned when opening an invalid database")
+	}
+}
+
+func TestGeoIPEnricherInvalidCacheSize(t *testing.T) {
+	_, err := NewGeoIPEnricher(GeoIPConfig{CacheSize: -1})
+
+	if e, ok := err.(FieldError); !ok || e.Name != "CacheSize" {
+		t.Error("invalid error returned for a negative cache size:", err)
+	}
+}
@@ -3,11 +3,16 @@ module github.com/rudderlabs/analytics-go/v4
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/google/uuid v1.6.0
+	github.com/klauspost/compress v1.17.11
+	github.com/oschwald/maxminddb-golang v1.13.1
 	github.com/segmentio/backo-go v1.1.0
 	github.com/segmentio/conf v1.3.1
 )
 
+require golang.org/x/sys v0.21.0 // indirect
+
 require (
 	github.com/joho/godotenv v1.5.1
 	github.com/kr/pretty v0.3.1 // indirect
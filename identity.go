@@ -0,0 +1,120 @@
+package analytics
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// This type tracks the identity of a visitor across the transition from an
+// anonymous to a known user, which is the typical login flow:
+//
+//	identity := analytics.NewIdentity(client, anonymousIdFromCookie)
+//	identity.Enqueue(analytics.Page{Name: "Login"})
+//	...
+//	identity.Login("0123456789", analytics.NewTraits().SetEmail(email))
+//	identity.Enqueue(analytics.Track{Event: "Signed In"})
+//	...
+//	identity.Logout()
+//
+// Messages queued through the identity get its user and anonymous ids when
+// they don't have their own.
+// Values of this type are safe to use concurrently.
+type Identity struct {
+	client Client
+
+	mutex       sync.Mutex
+	userId      string
+	anonymousId string
+}
+
+// Instantiate an identity tracking an anonymous visitor, a new anonymous id is
+// generated if the one passed as argument is empty.
+func NewIdentity(client Client, anonymousId string) *Identity {
+	return &Identity{
+		client:      client,
+		anonymousId: makeAnonymousId(anonymousId),
+	}
+}
+
+// Returns the current anonymous id of the identity.
+func (id *Identity) AnonymousId() string {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+	return id.anonymousId
+}
+
+// Returns the current user id of the identity, or an empty string if the user
+// isn't logged in.
+func (id *Identity) UserId() string {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+	return id.userId
+}
+
+// Identifies the visitor as the user passed as argument.
+//
+// The first time the anonymous visitor logs in, an alias from the anonymous id
+// to the user id is queued before the identify message so destinations merge
+// the anonymous activity into the user's profile. The identify message is
+// timestamped one millisecond after the alias so the order is preserved even
+// when the messages are delivered in different batches.
+// If another user was logged in, the identity is reset first and no alias is
+// sent since the anonymous activity belongs to the previous user.
+func (id *Identity) Login(userId string, traits Traits) error {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+
+	anonymousId := id.anonymousId
+	if len(id.userId) != 0 && id.userId != userId {
+		anonymousId = makeAnonymousId("")
+	}
+
+	now := time.Now()
+
+	if len(id.userId) == 0 {
+		if err := id.client.Enqueue(Alias{
+			PreviousId:        anonymousId,
+			UserId:            userId,
+			OriginalTimestamp: now,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if err := id.client.Enqueue(Identify{
+		UserId:            userId,
+		AnonymousId:       anonymousId,
+		Traits:            traits,
+		OriginalTimestamp: now.Add(time.Millisecond),
+	}); err != nil {
+		return err
+	}
+
+	id.userId, id.anonymousId = userId, anonymousId
+	return nil
+}
+
+// Forgets the user id and starts a new anonymous session.
+func (id *Identity) Logout() {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+	id.userId, id.anonymousId = "", makeAnonymousId("")
+}
+
+// Returns a copy of ctx carrying the ids of the identity, see `WithUser`.
+func (id *Identity) Context(ctx context.Context) context.Context {
+	id.mutex.Lock()
+	defer id.mutex.Unlock()
+	return WithUser(ctx, id.userId, id.anonymousId)
+}
+
+// Queues a message on the client of the identity, the ids of the identity are
+// set on the message when it doesn't have its own.
+func (id *Identity) Enqueue(msg Message) error {
+	return id.EnqueueContext(context.Background(), msg)
+}
+
+func (id *Identity) EnqueueContext(ctx context.Context, msg Message) error {
+	return EnqueueContext(id.Context(ctx), id.client, msg)
+}
@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"testing"
+)
+
+// Instances of this type are used to record the messages queued by helpers
+// built on top of clients.
+type testRecorder struct {
+	msgs []Message
+}
+
+func (r *testRecorder) Close() error { return nil }
+
+func (r *testRecorder) Enqueue(msg Message) error {
+	return r.EnqueueContext(context.Background(), msg)
+}
+
+func (r *testRecorder) EnqueueContext(ctx context.Context, msg Message) error {
+	r.msgs = append(r.msgs, propagate(ctx, msg))
+	return nil
+}
+
+func TestIdentityLogin(t *testing.T) {
+	rec := &testRecorder{}
+	id := NewIdentity(rec, "anon")
+
+	id.Enqueue(Page{Name: "Login"})
+
+	if err := id.Login("user", NewTraits().SetEmail("user@example.com")); err != nil {
+		t.Fatal("login failed:", err)
+	}
+
+	id.Enqueue(Track{Event: "Signed In"})
+
+	if len(rec.msgs) != 4 {
+		t.Fatalf("invalid number of messages queued: %d", len(rec.msgs))
+	}
+
+	if m := rec.msgs[0].(Page); m.AnonymousId != "anon" || m.UserId != "" {
+		t.Errorf("invalid ids on the anonymous page: %#v", m)
+	}
+
+	alias := rec.msgs[1].(Alias)
+	if alias.PreviousId != "anon" || alias.UserId != "user" {
+		t.Errorf("invalid alias: %#v", alias)
+	}
+
+	identify := rec.msgs[2].(Identify)
+	if identify.AnonymousId != "anon" || identify.UserId != "user" || identify.Traits["email"] != "user@example.com" {
+		t.Errorf("invalid identify: %#v", identify)
+	}
+
+	if !identify.OriginalTimestamp.After(alias.OriginalTimestamp) {
+		t.Error("the identify must be timestamped after the alias")
+	}
+
+	if m := rec.msgs[3].(Track); m.AnonymousId != "anon" || m.UserId != "user" {
+		t.Errorf("invalid ids on the track: %#v", m)
+	}
+}
+
+func TestIdentityLoginTwice(t *testing.T) {
+	rec := &testRecorder{}
+	id := NewIdentity(rec, "anon")
+
+	id.Login("user", nil)
+	id.Login("user", nil)
+
+	if len(rec.msgs) != 3 {
+		t.Fatalf("invalid number of messages queued: %d", len(rec.msgs))
+	}
+
+	if _, ok := rec.msgs[2].(Identify); !ok {
+		t.Errorf("the second login should only identify the user: %#v", rec.msgs[2])
+	}
+}
+
+func TestIdentitySwitchUser(t *testing.T) {
+	rec := &testRecorder{}
+	id := NewIdentity(rec, "anon")
+
+	id.Login("A", nil)
+	id.Login("B", nil)
+
+	if len(rec.msgs) != 3 {
+		t.Fatalf("invalid number of messages queued: %d", len(rec.msgs))
+	}
+
+	if m := rec.msgs[2].(Identify); m.UserId != "B" || m.AnonymousId == "anon" {
+		t.Errorf("the anonymous id of the previous user was reused: %#v", m)
+	}
+}
+
+func TestIdentityLogout(t *testing.T) {
+	id := NewIdentity(&testRecorder{}, "anon")
+	id.Login("user", nil)
+	id.Logout()
+
+	if id.UserId() != "" {
+		t.Error("the user id was not reset:", id.UserId())
+	}
+
+	if a := id.AnonymousId(); a == "anon" || a == "" {
+		t.Error("a new anonymous id was not generated:", a)
+	}
+}
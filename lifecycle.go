@@ -0,0 +1,79 @@
+package analytics
+
+// Names of the application lifecycle events defined by the Mobile spec.
+const (
+	EventApplicationInstalled    = "Application Installed"
+	EventApplicationOpened       = "Application Opened"
+	EventApplicationUpdated      = "Application Updated"
+	EventApplicationBackgrounded = "Application Backgrounded"
+)
+
+// This type represents the properties of the `Application Opened` event of
+// the Mobile spec.
+type ApplicationOpened struct {
+	FromBackground       bool   `json:"from_background"`
+	ReferringApplication string `json:"referring_application,omitempty"`
+	URL                  string `json:"url,omitempty"`
+	Version              string `json:"version,omitempty"`
+	Build                string `json:"build,omitempty"`
+}
+
+// This type represents the properties of the `Application Updated` event of
+// the Mobile spec.
+type ApplicationUpdated struct {
+	PreviousVersion string `json:"previous_version,omitempty"`
+	PreviousBuild   string `json:"previous_build,omitempty"`
+	Version         string `json:"version,omitempty"`
+	Build           string `json:"build,omitempty"`
+}
+
+func (a ApplicationUpdated) Validate() error {
+	if len(a.PreviousVersion) == 0 {
+		return FieldError{
+			Type:  "analytics.ApplicationUpdated",
+			Name:  "PreviousVersion",
+			Value: a.PreviousVersion,
+		}
+	}
+
+	if len(a.Version) == 0 {
+		return FieldError{
+			Type:  "analytics.ApplicationUpdated",
+			Name:  "Version",
+			Value: a.Version,
+		}
+	}
+
+	return nil
+}
+
+func NewApplicationInstalled(version string, build string) (Track, error) {
+	if len(version) == 0 {
+		return Track{}, FieldError{
+			Type:  "analytics.ApplicationInstalled",
+			Name:  "Version",
+			Value: version,
+		}
+	}
+
+	props := NewProperties().Set("version", version)
+	if len(build) != 0 {
+		props.Set("build", build)
+	}
+	return specTrack(EventApplicationInstalled, props), nil
+}
+
+func NewApplicationOpened(app ApplicationOpened) Track {
+	return specTrack(EventApplicationOpened, specProperties(app))
+}
+
+func NewApplicationUpdated(app ApplicationUpdated) (Track, error) {
+	if err := app.Validate(); err != nil {
+		return Track{}, err
+	}
+	return specTrack(EventApplicationUpdated, specProperties(app)), nil
+}
+
+func NewApplicationBackgrounded() Track {
+	return specTrack(EventApplicationBackgrounded, NewProperties())
+}
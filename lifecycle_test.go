@@ -0,0 +1,64 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplicationInstalled(t *testing.T) {
+	track, err := NewApplicationInstalled("3.0.1", "3001")
+	if err != nil {
+		t.Fatal("building the event failed:", err)
+	}
+
+	if track.Event != EventApplicationInstalled {
+		t.Error("invalid event name:", track.Event)
+	}
+
+	if ref := (Properties{"version": "3.0.1", "build": "3001"}); !reflect.DeepEqual(track.Properties, ref) {
+		t.Errorf("invalid properties produced: %#v", track.Properties)
+	}
+
+	if _, err := NewApplicationInstalled("", "3001"); err != (FieldError{
+		Type:  "analytics.ApplicationInstalled",
+		Name:  "Version",
+		Value: "",
+	}) {
+		t.Error("invalid error returned for a missing version:", err)
+	}
+}
+
+func TestApplicationOpened(t *testing.T) {
+	track := NewApplicationOpened(ApplicationOpened{Version: "3.0.1"})
+
+	if track.Event != EventApplicationOpened {
+		t.Error("invalid event name:", track.Event)
+	}
+
+	// The from_background property is always set, false is meaningful.
+	if ref := (Properties{"from_background": false, "version": "3.0.1"}); !reflect.DeepEqual(track.Properties, ref) {
+		t.Errorf("invalid properties produced: %#v", track.Properties)
+	}
+}
+
+func TestApplicationUpdated(t *testing.T) {
+	track, err := NewApplicationUpdated(ApplicationUpdated{
+		PreviousVersion: "3.0.0",
+		Version:         "3.0.1",
+	})
+	if err != nil {
+		t.Fatal("building the event failed:", err)
+	}
+
+	if ref := (Properties{"previous_version": "3.0.0", "version": "3.0.1"}); !reflect.DeepEqual(track.Properties, ref) {
+		t.Errorf("invalid properties produced: %#v", track.Properties)
+	}
+
+	if _, err := NewApplicationUpdated(ApplicationUpdated{Version: "3.0.1"}); err != (FieldError{
+		Type:  "analytics.ApplicationUpdated",
+		Name:  "PreviousVersion",
+		Value: "",
+	}) {
+		t.Error("invalid error returned for a missing previous version:", err)
+	}
+}
@@ -0,0 +1,77 @@
+package analytics
+
+import (
+	"container/list"
+	"sync"
+)
+
+// This type is a fixed-size cache evicting the least recently used entries
+// first, it is safe to use concurrently.
+type lruCache struct {
+	mutex sync.Mutex
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:  size,
+		items: make(map[string]*list.Element, size),
+		order: list.New(),
+	}
+}
+
+func (c *lruCache) get(key string) (value interface{}, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.items[key]; found {
+		c.order.MoveToFront(elem)
+		value, ok = elem.Value.(*lruEntry).value, true
+	}
+	return
+}
+
+// Adds or replaces an entry of the cache, evicting the least recently used one
+// if the cache is full.
+func (c *lruCache) add(key string, value interface{}) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.items[key]; found {
+		c.order.MoveToFront(elem)
+		elem.Value.(*lruEntry).value = value
+		return
+	}
+
+	if c.order.Len() >= c.size {
+		if oldest := c.order.Back(); oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*lruEntry).key)
+		}
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+}
+
+func (c *lruCache) remove(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if elem, found := c.items[key]; found {
+		c.order.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.order.Len()
+}
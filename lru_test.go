@@ -0,0 +1,42 @@
+package analytics
+
+import "testing"
+
+func TestLRUCacheEviction(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("b", 2)
+
+	// Reading "a" makes "b" the least recently used entry.
+	if v, ok := c.get("a"); !ok || v != 1 {
+		t.Error("invalid value read from the cache:", v, ok)
+	}
+
+	c.add("c", 3)
+
+	if _, ok := c.get("b"); ok {
+		t.Error("the least recently used entry was not evicted")
+	}
+
+	if v, ok := c.get("c"); !ok || v != 3 {
+		t.Error("invalid value read from the cache:", v, ok)
+	}
+
+	if n := c.len(); n != 2 {
+		t.Error("invalid cache size:", n)
+	}
+}
+
+func TestLRUCacheReplace(t *testing.T) {
+	c := newLRUCache(2)
+	c.add("a", 1)
+	c.add("a", 2)
+
+	if v, _ := c.get("a"); v != 2 {
+		t.Error("the entry was not replaced:", v)
+	}
+
+	if n := c.len(); n != 1 {
+		t.Error("invalid cache size:", n)
+	}
+}
@@ -1,10 +1,6 @@
 package analytics
 
-import (
-	"encoding/json"
-	"fmt"
-	"time"
-)
+import "time"
 
 // Values implementing this interface are used by analytics clients to notify
 // the application when a message send succeeded or failed.
@@ -48,6 +44,125 @@ func makeMessageId(id string, def string) string {
 	return id
 }
 
+// Returns the string passed as first argument, unless it's empty, in that case
+// the default value passed as second argument is returned.
+func makeString(s string, def string) string {
+	if len(s) == 0 {
+		return def
+	}
+	return s
+}
+
+// Returns the user id carried by the message passed as argument, or an empty
+// string if the message has none or is of a custom type.
+func messageUserId(msg Message) string {
+	switch m := msg.(type) {
+	case Alias:
+		return m.UserId
+	case Group:
+		return m.UserId
+	case Identify:
+		return m.UserId
+	case Page:
+		return m.UserId
+	case Screen:
+		return m.UserId
+	case Track:
+		return m.UserId
+	}
+	return ""
+}
+
+// Returns the id of the message passed as argument.
+func messageIdOf(msg Message) string {
+	switch m := msg.(type) {
+	case Alias:
+		return m.MessageId
+	case Group:
+		return m.MessageId
+	case Identify:
+		return m.MessageId
+	case Page:
+		return m.MessageId
+	case Screen:
+		return m.MessageId
+	case Track:
+		return m.MessageId
+	}
+	return ""
+}
+
+// Returns the anonymous id of the message passed as argument, aliases have
+// none.
+func messageAnonymousId(msg Message) string {
+	switch m := msg.(type) {
+	case Group:
+		return m.AnonymousId
+	case Identify:
+		return m.AnonymousId
+	case Page:
+		return m.AnonymousId
+	case Screen:
+		return m.AnonymousId
+	case Track:
+		return m.AnonymousId
+	}
+	return ""
+}
+
+// Returns the event name of tracks and the name of pages and screens, or an
+// empty string for other messages.
+func messageEventName(msg Message) string {
+	switch m := msg.(type) {
+	case Page:
+		return m.Name
+	case Screen:
+		return m.Name
+	case Track:
+		return m.Event
+	}
+	return ""
+}
+
+// Returns the type of the message passed as argument, as set by clients in the
+// "type" field of its JSON representation.
+func messageTypeName(msg Message) string {
+	switch msg.(type) {
+	case Alias:
+		return "alias"
+	case Group:
+		return "group"
+	case Identify:
+		return "identify"
+	case Page:
+		return "page"
+	case Screen:
+		return "screen"
+	case Track:
+		return "track"
+	}
+	return ""
+}
+
+// Returns the context of the message passed as argument, which may be nil.
+func messageContext(msg Message) *Context {
+	switch m := msg.(type) {
+	case Alias:
+		return m.Context
+	case Group:
+		return m.Context
+	case Identify:
+		return m.Context
+	case Page:
+		return m.Context
+	case Screen:
+		return m.Context
+	case Track:
+		return m.Context
+	}
+	return nil
+}
+
 // Returns the time value passed as first argument, unless it's the zero-value,
 // in that case the default value passed as second argument is returned.
 func makeTimestamp(t time.Time, def time.Time) time.Time {
@@ -67,14 +182,26 @@ type batch struct {
 type message struct {
 	msg  Message
 	json []byte
+
+	// The position of the sentAt value in the JSON representation of the
+	// message, both are zero if it's unknown.
+	sentAt, sentAtEnd int
+
+	// When the message was queued by the application.
+	queued time.Time
 }
 
 func makeMessage(m Message, maxBytes int) (msg message, err error) {
-	if msg.json, err = json.Marshal(m); err == nil {
+	e := getEncoder()
+	defer putEncoder(e)
+	e.message(m)
+
+	if msg.json, err = e.bytes(); err == nil {
 		if len(msg.json) > maxBytes {
 			err = ErrMessageTooBig
 		} else {
 			msg.msg = m
+			msg.sentAt, msg.sentAtEnd = e.sentAt, e.sentAtEnd
 		}
 	}
 	return
@@ -84,8 +211,10 @@ func (m message) MarshalJSON() ([]byte, error) {
 	return m.json, nil
 }
 
-// setSentAt remarshalls the message with a new sentAt timestamp
-func (m *message) setSentAt(ts time.Time) (err error) {
+// setSentAt splices a new sentAt timestamp in the JSON representation of the
+// message, which is patched in place when the length of the value is
+// unchanged.
+func (m *message) setSentAt(ts time.Time) {
 	switch msg := m.msg.(type) {
 	case Alias:
 		msg.SentAt = ts
@@ -105,13 +234,27 @@ func (m *message) setSentAt(ts time.Time) (err error) {
 	case Track:
 		msg.SentAt = ts
 		m.msg = msg
-	default:
-		err = fmt.Errorf("messages with custom types cannot be enqueued: %T", msg)
+	}
+
+	if m.sentAtEnd == 0 {
 		return
 	}
 
-	m.json, err = json.Marshal(m.msg)
-	return
+	var buf [64]byte
+	v := append(buf[:0], '"')
+	v = ts.AppendFormat(v, time.RFC3339Nano)
+	v = append(v, '"')
+
+	if len(v) == m.sentAtEnd-m.sentAt {
+		copy(m.json[m.sentAt:m.sentAtEnd], v)
+		return
+	}
+
+	b := make([]byte, 0, len(m.json)-(m.sentAtEnd-m.sentAt)+len(v))
+	b = append(b, m.json[:m.sentAt]...)
+	b = append(b, v...)
+	b = append(b, m.json[m.sentAtEnd:]...)
+	m.json, m.sentAtEnd = b, m.sentAt+len(v)
 }
 
 func (m message) size() int {
@@ -124,6 +267,13 @@ type messageQueue struct {
 	bytes         int
 	maxBatchSize  int
 	maxBatchBytes int
+
+	// The priority of the messages in the queue, high priority batches get
+	// first claim on the goroutines sending requests.
+	priority Priority
+
+	// When the oldest pending message was queued, zero if the queue is empty.
+	oldest time.Time
 }
 
 func (q *messageQueue) push(m message) (b []message) {
@@ -135,6 +285,10 @@ func (q *messageQueue) push(m message) (b []message) {
 		q.pending = make([]message, 0, q.maxBatchSize)
 	}
 
+	if len(q.pending) == 0 {
+		q.oldest = m.queued
+	}
+
 	q.pending = append(q.pending, m)
 	q.bytes += len(m.json)
 
@@ -146,7 +300,7 @@ func (q *messageQueue) push(m message) (b []message) {
 }
 
 func (q *messageQueue) flush() (msgs []message) {
-	msgs, q.pending, q.bytes = q.pending, nil, 0
+	msgs, q.pending, q.bytes, q.oldest = q.pending, nil, 0, time.Time{}
 	return
 }
 
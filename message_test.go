@@ -1,8 +1,10 @@
 package analytics
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestMessageIdDefault(t *testing.T) {
@@ -80,6 +82,9 @@ func TestMakeMessage(t *testing.T) {
 	} else if !reflect.DeepEqual(msg, message{
 		msg:  track,
 		json: []byte(`{"userId":"1","event":"","originalTimestamp":"0001-01-01T00:00:00Z","sentAt":"0001-01-01T00:00:00Z"}`),
+
+		sentAt:    77,
+		sentAtEnd: 99,
 	}) {
 		t.Error("invalid message generated from track message:", msg.msg, string(msg.json))
 	}
@@ -90,3 +95,40 @@ func TestMakeMessageTooBig(t *testing.T) {
 		t.Error("invalid error returned when creating a message bigger than the limit:", err)
 	}
 }
+
+func TestMessageSetSentAt(t *testing.T) {
+	ts := time.Date(2015, time.July, 10, 23, 0, 0, 0, time.UTC)
+	msg, _ := makeMessage(Track{UserId: "1", Event: "A", SentAt: ts, Channel: "server"}, defMaxMessageBytes)
+
+	tests := []time.Time{
+		// Same length, the value is patched in place.
+		ts.Add(time.Second),
+		// Different lengths, the value is spliced.
+		ts.Add(time.Millisecond),
+		ts.In(time.FixedZone("IST", 5*3600+1800)),
+	}
+
+	for _, sentAt := range tests {
+		msg.setSentAt(sentAt)
+
+		ref, _ := json.Marshal(Track{UserId: "1", Event: "A", SentAt: sentAt, Channel: "server"})
+
+		if string(msg.json) != string(ref) {
+			t.Errorf("invalid message after setting sentAt:\n- expected: %s\n- found:    %s", ref, msg.json)
+		}
+
+		if m := msg.msg.(Track); !m.SentAt.Equal(sentAt) {
+			t.Error("the sentAt field of the message was not updated:", m.SentAt)
+		}
+	}
+}
+
+func BenchmarkMessageSetSentAt(b *testing.B) {
+	msg, _ := makeMessage(benchmarkTrack(), defMaxMessageBytes)
+	ts := time.Now().UTC()
+	b.ReportAllocs()
+
+	for i := 0; i != b.N; i++ {
+		msg.setSentAt(ts)
+	}
+}
@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// This type represents functions used by clients to generate the id of
+// messages that don't have one.
+//
+// The function is called with the message once all its other fields were set
+// by the client, it may return an empty string to fall back to a random id.
+type MessageIdStrategy func(Message) string
+
+// Returns a message id strategy generating UUIDv5 from the namespace passed as
+// argument and the type, user id (or anonymous id if the message has no user
+// id), event name, original timestamp and the properties (or traits) named by
+// the remaining arguments.
+//
+// The same logical event therefore always gets the same id, which lets the
+// data plane and warehouses drop the duplicates created when an operation is
+// retried. Note that the application must set the original timestamp of the
+// messages itself since it otherwise defaults to the time they were queued.
+func DeterministicMessageId(namespace uuid.UUID, properties ...string) MessageIdStrategy {
+	props := append([]string(nil), properties...)
+	sort.Strings(props)
+
+	return func(msg Message) string {
+		typ, userId, event, ts, values, ok := messageIdFields(msg)
+		if !ok {
+			return ""
+		}
+
+		var b bytes.Buffer
+		b.WriteString(typ)
+		b.WriteByte(0)
+		b.WriteString(userId)
+		b.WriteByte(0)
+		b.WriteString(event)
+		b.WriteByte(0)
+		b.WriteString(ts.UTC().Format(time.RFC3339Nano))
+
+		for _, name := range props {
+			v, err := json.Marshal(values[name])
+			if err != nil {
+				return ""
+			}
+			b.WriteByte(0)
+			b.WriteString(name)
+			b.WriteByte('=')
+			b.Write(v)
+		}
+
+		return uuid.NewSHA1(namespace, b.Bytes()).String()
+	}
+}
+
+// Returns the fields of the message that identify the logical event it
+// represents, the last value is false if the message type is unknown.
+func messageIdFields(msg Message) (typ string, userId string, event string, ts time.Time, values map[string]interface{}, ok bool) {
+	switch m := msg.(type) {
+	case Alias:
+		return m.Type, m.UserId, m.PreviousId, m.OriginalTimestamp, nil, true
+	case Group:
+		return m.Type, makeString(m.UserId, m.AnonymousId), m.GroupId, m.OriginalTimestamp, m.Traits, true
+	case Identify:
+		return m.Type, makeString(m.UserId, m.AnonymousId), "", m.OriginalTimestamp, m.Traits, true
+	case Page:
+		return m.Type, makeString(m.UserId, m.AnonymousId), m.Name, m.OriginalTimestamp, m.Properties, true
+	case Screen:
+		return m.Type, makeString(m.UserId, m.AnonymousId), m.Name, m.OriginalTimestamp, m.Properties, true
+	case Track:
+		return m.Type, makeString(m.UserId, m.AnonymousId), m.Event, m.OriginalTimestamp, m.Properties, true
+	}
+	return
+}
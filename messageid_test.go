@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestDeterministicMessageId(t *testing.T) {
+	strategy := DeterministicMessageId(uuid.NameSpaceURL, "order_id")
+	ts := time.Date(2015, time.July, 10, 23, 0, 0, 0, time.UTC)
+
+	a := strategy(Track{
+		Type:              "track",
+		UserId:            "A",
+		Event:             "Order Completed",
+		OriginalTimestamp: ts,
+		Properties:        Properties{"order_id": "42", "total": 10},
+	})
+	b := strategy(Track{
+		Type:              "track",
+		UserId:            "A",
+		Event:             "Order Completed",
+		OriginalTimestamp: ts,
+		Properties:        Properties{"order_id": "42", "total": 20},
+	})
+
+	if a != b {
+		t.Errorf("the same logical event produced different message ids: %s != %s", a, b)
+	}
+
+	if id, err := uuid.Parse(a); err != nil || id.Version() != 5 {
+		t.Errorf("the message id is not a UUIDv5: %s", a)
+	}
+
+	tests := map[string]Message{
+		"event": Track{
+			Type:              "track",
+			UserId:            "A",
+			Event:             "Order Refunded",
+			OriginalTimestamp: ts,
+			Properties:        Properties{"order_id": "42"},
+		},
+		"user": Track{
+			Type:              "track",
+			UserId:            "B",
+			Event:             "Order Completed",
+			OriginalTimestamp: ts,
+			Properties:        Properties{"order_id": "42"},
+		},
+		"timestamp": Track{
+			Type:              "track",
+			UserId:            "A",
+			Event:             "Order Completed",
+			OriginalTimestamp: ts.Add(time.Second),
+			Properties:        Properties{"order_id": "42"},
+		},
+		"property": Track{
+			Type:              "track",
+			UserId:            "A",
+			Event:             "Order Completed",
+			OriginalTimestamp: ts,
+			Properties:        Properties{"order_id": "43"},
+		},
+	}
+
+	for name, msg := range tests {
+		t.Run(name, func(t *testing.T) {
+			if id := strategy(msg); id == a {
+				t.Error("different events produced the same message id:", id)
+			}
+		})
+	}
+}
+
+func TestClientMessageIdStrategy(t *testing.T) {
+	c := &client{
+		Config: makeConfig(Config{
+			MessageIdStrategy: func(msg Message) string {
+				if m, ok := msg.(Track); ok {
+					return m.Event
+				}
+				return ""
+			},
+			uid: mockId,
+		}),
+	}
+
+	if id := c.makeMessageId("", Track{Event: "Download"}); id != "Download" {
+		t.Error("the message id strategy was not used:", id)
+	}
+
+	if id := c.makeMessageId("", Page{Name: "Home"}); id != mockId() {
+		t.Error("the default message id was not used:", id)
+	}
+
+	if id := c.makeMessageId("A", Track{Event: "Download"}); id != "A" {
+		t.Error("the message id was overwritten:", id)
+	}
+}
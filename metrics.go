@@ -0,0 +1,49 @@
+package analytics
+
+// Instances of types implementing this interface can be used to collect the
+// metrics reported by analytics clients, for example to forward them to
+// statsd or prometheus.
+type Metrics interface {
+
+	// Analytics clients call this method to increment the counter with the
+	// given name by value.
+	Count(name string, value int64)
+
+	// Analytics clients call this method to set the current value of the gauge
+	// with the given name.
+	Gauge(name string, value float64)
+}
+
+// Names of the metrics reported by analytics clients.
+const (
+	// Number of messages dropped by `Enqueue` because a message with the same
+	// id was already queued within the deduplication window.
+	MetricMessagesDuplicate = "analytics.messages.duplicate"
+
+	// Number of messages dropped by `Enqueue` because their user was not part
+	// of the sample selected by the sampler.
+	MetricMessagesSampled = "analytics.messages.sampled"
+
+	// Number of messages dropped by `Enqueue` because the rate limit of the
+	// sampler was reached.
+	MetricMessagesRateLimited = "analytics.messages.rate_limited"
+
+	// Number of tenants currently held by a pool.
+	MetricPoolTenants = "analytics.pool.tenants"
+
+	// Number of batches that the client currently uploads concurrently, only
+	// reported when `Config.Concurrency` is set.
+	MetricConcurrencyLimit = "analytics.concurrency.limit"
+
+	// Number of messages restored from the spill directory of the circuit
+	// breaker that were sent, or dropped, they are not reported to the
+	// callback of the client.
+	MetricSpilledMessagesSent    = "analytics.spilled_messages.sent"
+	MetricSpilledMessagesDropped = "analytics.spilled_messages.dropped"
+)
+
+type discardMetrics struct{}
+
+func (discardMetrics) Count(name string, value int64) {}
+
+func (discardMetrics) Gauge(name string, value float64) {}
@@ -0,0 +1,203 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ ContextClient = (*multiClient)(nil)
+
+// Instances of this type describe one of the destinations of a multi client,
+// see `NewMultiClient`.
+type Endpoint struct {
+
+	// The write key used to send messages to the endpoint.
+	WriteKey string
+
+	// The configuration of the client sending messages to the endpoint, each
+	// endpoint has its own batching, retries and callbacks.
+	Config Config
+
+	// When set, messages are dropped for this endpoint when its queue is full
+	// instead of waiting for room to be available, so a slow endpoint doesn't
+	// block the others. The setting is ignored on the first endpoint, which is
+	// the primary destination of the messages.
+	DropWhenFull bool
+}
+
+// Instantiate a client that sends each message to all the endpoints passed as
+// arguments, which is useful to mirror the traffic to a shadow data plane
+// during a migration for example.
+//
+// The messages are sent by one client per endpoint, each endpoint has its own
+// queue. When the queue of the first endpoint is full `Enqueue` waits for room
+// to be available, like clients do. The other endpoints can be configured with
+// `DropWhenFull` so they never block `Enqueue`: when their queue is full the
+// message is dropped for this endpoint only and `Enqueue` returns an error
+// wrapping `ErrQueueFull`. The message id, timestamp and anonymous id of messages are
+// generated once before they're fanned out, so all endpoints receive the same
+// values, the id strategies set on the endpoint configurations are therefore
+// not used.
+//
+// The function returns an error if no endpoints were given or if one of the
+// configurations contained impossible values.
+func NewMultiClient(endpoints ...Endpoint) (Client, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("analytics.NewMultiClient: at least one endpoint is required")
+	}
+
+	m := &multiClient{
+		clients:      make([]Client, 0, len(endpoints)),
+		dropWhenFull: make([]bool, 0, len(endpoints)),
+		uid:          uid,
+		now:          time.Now,
+	}
+
+	for i, e := range endpoints {
+		c, err := NewWithConfig(e.WriteKey, e.Config)
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("endpoint %d: %w", i, err)
+		}
+		m.clients = append(m.clients, c)
+		m.dropWhenFull = append(m.dropWhenFull, i != 0 && e.DropWhenFull)
+	}
+
+	return m, nil
+}
+
+type multiClient struct {
+	clients      []Client
+	dropWhenFull []bool
+	uid          func() string
+	now          func() time.Time
+}
+
+func (m *multiClient) Enqueue(msg Message) error {
+	return m.EnqueueContext(context.Background(), msg)
+}
+
+func (m *multiClient) EnqueueContext(ctx context.Context, msg Message) error {
+	msg = propagate(ctx, dereferenceMessage(msg))
+
+	if err := msg.Validate(); err != nil {
+		return err
+	}
+
+	msg = m.prefill(msg)
+
+	// Enqueuing with a canceled context never waits for room to be available
+	// in the queue of a client, which is what prevents a slow endpoint from
+	// blocking the others.
+	nowait, cancel := context.WithCancel(ctx)
+	cancel()
+
+	var errs []error
+
+	for i, c := range m.clients {
+		drop := i < len(m.dropWhenFull) && m.dropWhenFull[i]
+
+		var err error
+		if drop {
+			err = EnqueueContext(nowait, c, copyContext(msg))
+		} else {
+			err = EnqueueContext(ctx, c, copyContext(msg))
+		}
+
+		if drop && err == context.Canceled && ctx.Err() == nil {
+			err = ErrQueueFull
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Sets the message id, timestamp and anonymous id of msg if they are missing,
+// so they're the same for all endpoints.
+func (m *multiClient) prefill(msg Message) Message {
+	ts := m.now()
+
+	switch v := msg.(type) {
+	case Alias:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		return v
+
+	case Group:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		v.AnonymousId = makeString(v.AnonymousId, m.uid())
+		return v
+
+	case Identify:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		return v
+
+	case Page:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		v.AnonymousId = makeString(v.AnonymousId, m.uid())
+		return v
+
+	case Screen:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		v.AnonymousId = makeString(v.AnonymousId, m.uid())
+		return v
+
+	case Track:
+		v.MessageId = makeMessageId(v.MessageId, m.uid())
+		v.OriginalTimestamp = makeTimestamp(v.OriginalTimestamp, ts)
+		v.AnonymousId = makeString(v.AnonymousId, m.uid())
+		return v
+	}
+
+	return msg
+}
+
+// Returns a copy of msg with its own copy of the context, clients modify the
+// context of the messages they queue so it can't be shared between endpoints.
+func copyContext(msg Message) Message {
+	switch v := msg.(type) {
+	case Alias:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	case Group:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	case Identify:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	case Page:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	case Screen:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	case Track:
+		v.Context = mergeContext(nil, v.Context)
+		return v
+	}
+	return msg
+}
+
+// Closes all the clients of the multi client, the method returns once all of
+// them have flushed their queued messages.
+func (m *multiClient) Close() error {
+	var errs []error
+
+	for i, c := range m.clients {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("endpoint %d: %w", i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,188 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Instances of this type simulate clients whose queue is always full.
+type testFullClient struct{}
+
+func (testFullClient) Close() error { return nil }
+
+func (c testFullClient) Enqueue(msg Message) error {
+	return c.EnqueueContext(context.Background(), msg)
+}
+
+func (testFullClient) EnqueueContext(ctx context.Context, msg Message) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestMultiClientSameIds(t *testing.T) {
+	a, b := &testRecorder{}, &testRecorder{}
+	m := &multiClient{clients: []Client{a, b}, uid: uid, now: mockTime}
+
+	msg := Track{UserId: "A", Event: "Download", Context: &Context{IP: net.IPv4(127, 0, 0, 1)}}
+
+	if err := m.Enqueue(msg); err != nil {
+		t.Fatal("queuing the message failed:", err)
+	}
+
+	if len(a.msgs) != 1 || len(b.msgs) != 1 {
+		t.Fatalf("the message was not sent to all endpoints: %d, %d", len(a.msgs), len(b.msgs))
+	}
+
+	ta, tb := a.msgs[0].(Track), b.msgs[0].(Track)
+
+	if ta.MessageId == "" || ta.MessageId != tb.MessageId {
+		t.Errorf("the endpoints received different message ids: %q, %q", ta.MessageId, tb.MessageId)
+	}
+
+	if ta.AnonymousId == "" || ta.AnonymousId != tb.AnonymousId {
+		t.Errorf("the endpoints received different anonymous ids: %q, %q", ta.AnonymousId, tb.AnonymousId)
+	}
+
+	if ta.Context == tb.Context && ta.Context != nil {
+		t.Error("the endpoints received the same context")
+	}
+
+	if !ta.OriginalTimestamp.Equal(mockTime()) || !tb.OriginalTimestamp.Equal(mockTime()) {
+		t.Errorf("the endpoints received different timestamps: %s, %s", ta.OriginalTimestamp, tb.OriginalTimestamp)
+	}
+}
+
+// Instances of this type simulate clients whose queue is full until the ready
+// channel is closed.
+type testBusyClient struct {
+	testRecorder
+	ready chan struct{}
+}
+
+func (c *testBusyClient) Enqueue(msg Message) error {
+	return c.EnqueueContext(context.Background(), msg)
+}
+
+func (c *testBusyClient) EnqueueContext(ctx context.Context, msg Message) error {
+	select {
+	case <-c.ready:
+		return c.testRecorder.EnqueueContext(ctx, msg)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestMultiClientFullQueue(t *testing.T) {
+	rec := &testRecorder{}
+	m := &multiClient{
+		clients:      []Client{rec, testFullClient{}},
+		dropWhenFull: []bool{false, true},
+		uid:          uid,
+		now:          mockTime,
+	}
+
+	err := m.Enqueue(Track{UserId: "A", Event: "Download"})
+
+	if !errors.Is(err, ErrQueueFull) {
+		t.Error("invalid error returned for a full queue:", err)
+	}
+
+	if len(rec.msgs) != 1 {
+		t.Error("the full queue of an endpoint blocked the others")
+	}
+}
+
+func TestMultiClientFullPrimaryQueue(t *testing.T) {
+	primary := &testBusyClient{ready: make(chan struct{})}
+	rec := &testRecorder{}
+	m := &multiClient{
+		clients:      []Client{primary, rec},
+		dropWhenFull: []bool{false, true},
+		uid:          uid,
+		now:          mockTime,
+	}
+
+	// The queue of the primary endpoint is full for a short while, the
+	// message must wait for room instead of being dropped.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(primary.ready)
+	}()
+
+	if err := m.Enqueue(Track{UserId: "A", Event: "Download"}); err != nil {
+		t.Error("queuing the message failed:", err)
+	}
+
+	if len(primary.msgs) != 1 || len(rec.msgs) != 1 {
+		t.Errorf("the message was not sent to all endpoints: %d, %d", len(primary.msgs), len(rec.msgs))
+	}
+
+	// The wait of the primary endpoint is bounded by the context.
+	m.clients[0] = testFullClient{}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := m.EnqueueContext(ctx, Track{UserId: "A", Event: "Download"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("invalid error returned when the context expired:", err)
+	}
+}
+
+func TestMultiClientInvalidConfig(t *testing.T) {
+	if _, err := NewMultiClient(); err == nil {
+		t.Error("creating a multi client without endpoints should fail")
+	}
+
+	_, err := NewMultiClient(
+		Endpoint{WriteKey: "A"},
+		Endpoint{WriteKey: "B", Config: Config{BatchSize: -1}},
+	)
+
+	if _, ok := errors.Unwrap(err).(ConfigError); !ok {
+		t.Error("invalid error returned for an invalid configuration:", err)
+	}
+}
+
+func TestMultiClientCallbacks(t *testing.T) {
+	var mutex sync.Mutex
+	ids := map[string][]string{}
+
+	callback := func(key string) Callback {
+		return testCallback{
+			success: func(msg Message) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				ids[key] = append(ids[key], msg.(Track).MessageId)
+			},
+		}
+	}
+
+	client, err := NewMultiClient(
+		Endpoint{WriteKey: "A", Config: Config{
+			Logger:    testLogger{t.Logf, t.Logf},
+			Transport: testTransportOK,
+			Callback:  callback("A"),
+		}},
+		Endpoint{WriteKey: "B", Config: Config{
+			Logger:    testLogger{t.Logf, t.Logf},
+			Transport: testTransportOK,
+			Callback:  callback("B"),
+		}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Enqueue(Track{UserId: "A", Event: "Download"})
+
+	if err := client.Close(); err != nil {
+		t.Fatal("closing the client failed:", err)
+	}
+
+	if len(ids["A"]) != 1 || len(ids["B"]) != 1 || ids["A"][0] != ids["B"][0] {
+		t.Errorf("invalid messages sent to the endpoints: %v", ids)
+	}
+}
@@ -0,0 +1,283 @@
+package analytics
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This interface is implemented by pools of clients, which send messages on
+// behalf of many tenants each having their own write key.
+type Pool interface {
+	io.Closer
+
+	// Queues a message to be sent with the write key passed as first argument,
+	// see `Client.Enqueue`.
+	Enqueue(writeKey string, msg Message) error
+
+	// Same as Enqueue but honors the context.Context like
+	// `ContextClient.EnqueueContext` does.
+	EnqueueContext(ctx context.Context, writeKey string, msg Message) error
+}
+
+// Instantiate a pool that sends messages to the backend with the configuration
+// passed as argument.
+//
+// Unlike creating one client per write key, all the tenants of a pool share a
+// single batching goroutine, HTTP client and pool of goroutines sending the
+// requests, only the batches are kept per write key. Tenants are created when
+// they queue their first message and evicted once they haven't queued any
+// messages for `Config.IdleTimeout`, which resets their deduplication state.
+//
+// The function will return an error if the configuration contained impossible
+// values.
+func NewPool(config Config) (Pool, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	if config.Protocol != ProtocolBatch {
+		return nil, ConfigError{
+			Reason: "pools only support the batch protocol",
+			Field:  "Protocol",
+			Value:  config.Protocol,
+		}
+	}
+
+	p := &pool{
+		Config:   makeConfig(config),
+		msgs:     make(chan poolMessage, 100),
+		quit:     make(chan struct{}),
+		shutdown: make(chan struct{}),
+		tenants:  make(map[string]*tenant),
+	}
+	p.http = makeHttpClient(p.Transport, p.Timeout)
+	p.transport = ownedTransport(config, p.Config)
+
+	go p.loop()
+	return p, nil
+}
+
+type pool struct {
+	Config
+
+	// This channel is where the `Enqueue` method writes messages so they can be
+	// picked up by the backend goroutine and pushed to the batch of their
+	// tenant.
+	msgs chan poolMessage
+
+	// These channels synchronize the pool shutting down, the same way they do
+	// for clients.
+	quit     chan struct{}
+	shutdown chan struct{}
+
+	// The HTTP client shared by all the tenants of the pool, and the transport
+	// created for the pool when the configuration didn't have one.
+	http      http.Client
+	transport *http.Transport
+
+	mutex   sync.Mutex
+	tenants map[string]*tenant
+}
+
+type poolMessage struct {
+	tenant *tenant
+	msg    Message
+}
+
+// A tenant is a client without its own goroutines, its messages are batched
+// and sent by the pool it belongs to.
+type tenant struct {
+	*client
+
+	// The batch of messages queued by the tenant, only accessed by the backend
+	// goroutine of the pool.
+	queue messageQueue
+
+	// When the tenant last queued a message, protected by the pool mutex.
+	last time.Time
+}
+
+func (p *pool) Enqueue(writeKey string, msg Message) error {
+	return p.EnqueueContext(context.Background(), writeKey, msg)
+}
+
+func (p *pool) EnqueueContext(ctx context.Context, writeKey string, msg Message) (err error) {
+	t := p.tenant(writeKey)
+
+	if msg, err = t.prepare(ctx, msg); err != nil {
+		return
+	}
+
+	defer func() {
+		// Writing to the `msgs` channel panics once the pool was closed.
+		if recover() != nil {
+			err = ErrClosed
+		}
+		if err != nil {
+			t.discard(msg)
+		}
+	}()
+
+	m := poolMessage{tenant: t, msg: msg}
+
+	select {
+	case p.msgs <- m:
+	default:
+		select {
+		case p.msgs <- m:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	return
+}
+
+// Returns the tenant for the write key, creating it if it didn't exist.
+func (p *pool) tenant(writeKey string) *tenant {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if t := p.tenants[writeKey]; t != nil {
+		return t
+	}
+
+	c := &client{
+		Config:       p.Config,
+		key:          writeKey,
+		quit:         p.quit,
+		http:         p.http,
+		totalNodes:   1,
+		suppressions: &suppressionList{},
+	}
+
+	c.uploader = batchUploader{c}
+
+	if c.DedupWindow != 0 {
+		c.dedup = newDedupCache(c.DedupWindow, c.DedupCacheSize)
+	}
+
+	t := &tenant{
+		client: c,
+		queue: messageQueue{
+			maxBatchSize:  c.BatchSize,
+			maxBatchBytes: c.maxBatchBytes(),
+		},
+		last: p.now(),
+	}
+
+	p.tenants[writeKey] = t
+	p.Metrics.Gauge(MetricPoolTenants, float64(len(p.tenants)))
+	return t
+}
+
+// Close flushes the batches of all tenants and waits for the requests in
+// flight to complete.
+func (p *pool) Close() (err error) {
+	defer func() {
+		// Always recover, a panic could be raised if `p`.quit was closed which
+		// means the method was called more than once.
+		if recover() != nil {
+			err = ErrClosed
+		}
+	}()
+	close(p.quit)
+	<-p.shutdown
+
+	if p.transport != nil {
+		p.transport.CloseIdleConnections()
+	}
+	return
+}
+
+// Batch loop of the pool.
+func (p *pool) loop() {
+	defer close(p.shutdown)
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	tick := time.NewTicker(p.Interval)
+	defer tick.Stop()
+
+	ex := newExecutor(p.maxConcurrentRequests)
+	ex.adaptive = p.Concurrency
+	defer ex.close()
+
+	for {
+		select {
+		case m := <-p.msgs:
+			p.push(m, wg, ex)
+
+		case <-tick.C:
+			p.flush(wg, ex)
+			p.evict()
+
+		case <-p.quit:
+			// Drain the msg channel, we have to close it first so no more
+			// messages can be pushed and otherwise the loop would never end.
+			close(p.msgs)
+			for m := range p.msgs {
+				p.push(m, wg, ex)
+			}
+
+			p.flush(wg, ex)
+			return
+		}
+	}
+}
+
+func (p *pool) push(m poolMessage, wg *sync.WaitGroup, ex *executor) {
+	t := m.tenant
+
+	p.mutex.Lock()
+	// The tenant may have been evicted after the message was prepared, in
+	// which case it's either restored or replaced by the one that was created
+	// since then.
+	if cur := p.tenants[t.key]; cur != nil {
+		t = cur
+	} else {
+		p.tenants[t.key] = t
+		p.Metrics.Gauge(MetricPoolTenants, float64(len(p.tenants)))
+	}
+	now := p.now()
+	t.last = now
+	p.mutex.Unlock()
+
+	t.push(&t.queue, m.msg, now, wg, ex)
+}
+
+// Flushes the queues of all the tenants. The queues are only used by the batch
+// loop, the mutex is released before the batches are handed to the executor so
+// `Enqueue` doesn't wait for room in the executor.
+func (p *pool) flush(wg *sync.WaitGroup, ex *executor) {
+	p.mutex.Lock()
+	tenants := make([]*tenant, 0, len(p.tenants))
+	for _, t := range p.tenants {
+		tenants = append(tenants, t)
+	}
+	p.mutex.Unlock()
+
+	for _, t := range tenants {
+		t.flush(&t.queue, wg, ex)
+	}
+}
+
+// Removes the tenants that have no pending messages and haven't queued any for
+// the idle timeout.
+func (p *pool) evict() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := p.now()
+
+	for key, t := range p.tenants {
+		if len(t.queue.pending) == 0 && now.Sub(t.last) >= p.IdleTimeout {
+			delete(p.tenants, key)
+		}
+	}
+
+	p.Metrics.Gauge(MetricPoolTenants, float64(len(p.tenants)))
+}
@@ -0,0 +1,141 @@
+package analytics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolBatchesPerWriteKey(t *testing.T) {
+	var mutex sync.Mutex
+	batches := map[string][][]string{}
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		key, _, _ := r.BasicAuth()
+
+		var b struct {
+			Batch []struct {
+				UserId string `json:"userId"`
+			} `json:"batch"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &b)
+
+		var users []string
+		for _, m := range b.Batch {
+			users = append(users, m.UserId)
+		}
+
+		mutex.Lock()
+		batches[key] = append(batches[key], users)
+		mutex.Unlock()
+
+		return testTransportOK.RoundTrip(r)
+	})
+
+	p, err := NewPool(Config{
+		Logger:      testLogger{t.Logf, t.Logf},
+		Transport:   transport,
+		DisableGzip: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.Enqueue("A", Track{UserId: "a1", Event: "Download"})
+	p.Enqueue("B", Track{UserId: "b1", Event: "Download"})
+	p.Enqueue("A", Track{UserId: "a2", Event: "Download"})
+
+	if err := p.Close(); err != nil {
+		t.Fatal("closing the pool failed:", err)
+	}
+
+	if b := batches["A"]; len(b) != 1 || len(b[0]) != 2 || b[0][0] != "a1" || b[0][1] != "a2" {
+		t.Errorf("invalid batches sent for write key A: %v", b)
+	}
+
+	if b := batches["B"]; len(b) != 1 || len(b[0]) != 1 || b[0][0] != "b1" {
+		t.Errorf("invalid batches sent for write key B: %v", b)
+	}
+}
+
+func TestPoolEvictsIdleTenants(t *testing.T) {
+	metrics := &testMetrics{}
+
+	p, _ := NewPool(Config{
+		Logger:      testLogger{t.Logf, t.Logf},
+		Transport:   testTransportOK,
+		Interval:    10 * time.Millisecond,
+		IdleTimeout: 20 * time.Millisecond,
+		Metrics:     metrics,
+	})
+	defer p.Close()
+
+	p.Enqueue("A", Track{UserId: "A", Event: "Download"})
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		pl := p.(*pool)
+		pl.mutex.Lock()
+		n := len(pl.tenants)
+		pl.mutex.Unlock()
+
+		if n == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("the idle tenant was not evicted")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if g := metrics.gauge(MetricPoolTenants); g != 0 {
+		t.Error("invalid number of tenants reported:", g)
+	}
+}
+
+func TestPoolClosed(t *testing.T) {
+	p, _ := NewPool(Config{Transport: testTransportOK})
+	p.Close()
+
+	if err := p.Enqueue("A", Track{UserId: "A", Event: "Download"}); err != ErrClosed {
+		t.Error("invalid error returned by a closed pool:", err)
+	}
+
+	if err := p.Close(); err != ErrClosed {
+		t.Error("invalid error returned when closing the pool twice:", err)
+	}
+}
+
+func TestPoolInvalidConfig(t *testing.T) {
+	if _, err := NewPool(Config{IdleTimeout: -1}); err == nil {
+		t.Error("a negative idle timeout should be rejected")
+	}
+
+	if _, err := NewPool(Config{Protocol: ProtocolStream}); err == nil {
+		t.Error("the stream protocol should be rejected")
+	}
+}
+
+func TestPoolPushTime(t *testing.T) {
+	p := &pool{
+		Config:  makeConfig(Config{now: mockTime}),
+		tenants: make(map[string]*tenant),
+	}
+
+	ten := p.tenant("A")
+	ex := newExecutor(1)
+	defer ex.close()
+
+	p.push(poolMessage{tenant: ten, msg: Track{UserId: "A", Event: "Download"}}, &sync.WaitGroup{}, ex)
+
+	if !ten.queue.oldest.Equal(mockTime()) || !ten.last.Equal(mockTime()) {
+		t.Errorf("the time of the pool should be used when queuing messages: %s, %s", ten.queue.oldest, ten.last)
+	}
+}
@@ -0,0 +1,33 @@
+package analytics
+
+// Priority values are used to route messages to the lanes of a client, see
+// `Config.Priority`.
+type Priority int
+
+const (
+	// Messages of normal priority are batched and sent when the batch is full
+	// or when the flushing interval timer triggers.
+	PriorityNormal Priority = iota
+
+	// Messages of high priority have their own queue, their batches are sent
+	// immediately and get first claim on the goroutines sending requests.
+	PriorityHigh
+)
+
+// Returns a function that can be set as `Config.Priority` to give a high
+// priority to the tracks of the events passed as arguments.
+func HighPriorityEvents(events ...string) func(Message) Priority {
+	set := make(map[string]struct{}, len(events))
+	for _, e := range events {
+		set[e] = struct{}{}
+	}
+
+	return func(msg Message) Priority {
+		if m, ok := msg.(Track); ok {
+			if _, found := set[m.Event]; found {
+				return PriorityHigh
+			}
+		}
+		return PriorityNormal
+	}
+}
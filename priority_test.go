@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestHighPriorityEvents(t *testing.T) {
+	priority := HighPriorityEvents("Signed Up", "Order Completed")
+
+	if p := priority(Track{Event: "Order Completed"}); p != PriorityHigh {
+		t.Error("invalid priority of a listed event:", p)
+	}
+
+	if p := priority(Track{Event: "Download"}); p != PriorityNormal {
+		t.Error("invalid priority of an unlisted event:", p)
+	}
+
+	if p := priority(Page{Name: "Order Completed"}); p != PriorityNormal {
+		t.Error("invalid priority of a page:", p)
+	}
+}
+
+func TestEnqueueHighPriority(t *testing.T) {
+	events := make(chan []string, 10)
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		var b struct {
+			Batch []struct {
+				Type  string `json:"type"`
+				Event string `json:"event"`
+			} `json:"batch"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &b)
+
+		var names []string
+		for _, m := range b.Batch {
+			names = append(names, m.Type+":"+m.Event)
+		}
+		events <- names
+
+		return testTransportOK.RoundTrip(r)
+	})
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Logger:      testLogger{t.Logf, t.Logf},
+		Transport:   transport,
+		Interval:    time.Hour,
+		DisableGzip: true,
+		Priority:    HighPriorityEvents("Order Completed"),
+	})
+
+	client.Enqueue(Page{UserId: "A", Name: "Home"})
+	client.Enqueue(Track{UserId: "A", Event: "Order Completed"})
+
+	select {
+	case names := <-events:
+		if len(names) != 1 || names[0] != "track:Order Completed" {
+			t.Errorf("invalid high priority batch: %v", names)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the high priority message was not flushed immediately")
+	}
+
+	client.Close()
+
+	if names := <-events; len(names) != 1 || names[0] != "page:" {
+		t.Errorf("invalid normal priority batch: %v", names)
+	}
+}
@@ -0,0 +1,139 @@
+package analytics
+
+import (
+	"context"
+	"reflect"
+)
+
+// The types of the keys used to store values in a context.Context, they are
+// unexported to avoid collisions with keys defined in other packages.
+type (
+	userContextKey      struct{}
+	analyticsContextKey struct{}
+)
+
+type contextUser struct {
+	userId      string
+	anonymousId string
+}
+
+// Returns a copy of ctx carrying the user identifiers passed as arguments,
+// messages queued with `EnqueueContext` that don't have their own identifiers
+// are sent with these ones.
+// Empty identifiers are ignored.
+func WithUser(ctx context.Context, userId string, anonymousId string) context.Context {
+	u, _ := ctx.Value(userContextKey{}).(contextUser)
+	if len(userId) != 0 {
+		u.userId = userId
+	}
+	if len(anonymousId) != 0 {
+		u.anonymousId = anonymousId
+	}
+	return context.WithValue(ctx, userContextKey{}, u)
+}
+
+// Returns the user identifiers stored in ctx by `WithUser`.
+func UserFromContext(ctx context.Context) (userId string, anonymousId string) {
+	u, _ := ctx.Value(userContextKey{}).(contextUser)
+	return u.userId, u.anonymousId
+}
+
+// Returns a copy of ctx carrying the analytics context passed as argument,
+// messages queued with `EnqueueContext` are sent with the fields of this
+// context that they didn't set themselves.
+// The analytics context must not be modified after the call since it may be
+// read concurrently by the goroutines the ctx is passed to.
+func WithAnalyticsContext(ctx context.Context, c *Context) context.Context {
+	return context.WithValue(ctx, analyticsContextKey{}, c)
+}
+
+// Returns the analytics context stored in ctx by `WithAnalyticsContext`, or
+// nil if there is none.
+func AnalyticsContextFromContext(ctx context.Context) *Context {
+	c, _ := ctx.Value(analyticsContextKey{}).(*Context)
+	return c
+}
+
+// Sets the values carried by ctx on the message, fields of the message take
+// precedence over the values of ctx.
+func propagate(ctx context.Context, msg Message) Message {
+	userId, anonymousId := UserFromContext(ctx)
+	base := AnalyticsContextFromContext(ctx)
+
+	if len(userId) == 0 && len(anonymousId) == 0 && base == nil {
+		return msg
+	}
+
+	switch m := msg.(type) {
+	case Alias:
+		m.UserId = makeString(m.UserId, userId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+
+	case Group:
+		m.UserId = makeString(m.UserId, userId)
+		m.AnonymousId = makeString(m.AnonymousId, anonymousId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+
+	case Identify:
+		m.UserId = makeString(m.UserId, userId)
+		m.AnonymousId = makeString(m.AnonymousId, anonymousId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+
+	case Page:
+		m.UserId = makeString(m.UserId, userId)
+		m.AnonymousId = makeString(m.AnonymousId, anonymousId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+
+	case Screen:
+		m.UserId = makeString(m.UserId, userId)
+		m.AnonymousId = makeString(m.AnonymousId, anonymousId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+
+	case Track:
+		m.UserId = makeString(m.UserId, userId)
+		m.AnonymousId = makeString(m.AnonymousId, anonymousId)
+		m.Context = mergeContext(m.Context, base)
+		return m
+	}
+
+	return msg
+}
+
+// Returns a new context made of the fields of the base context, overridden by
+// the non-zero fields of the message context. The extra fields are merged the
+// same way. Neither of the arguments is modified.
+func mergeContext(msg *Context, base *Context) *Context {
+	if base == nil {
+		return msg
+	}
+
+	merged := *base
+	merged.Extra = make(map[string]interface{}, len(base.Extra))
+	for k, v := range base.Extra {
+		merged.Extra[k] = v
+	}
+
+	if msg == nil {
+		return &merged
+	}
+
+	dst := reflect.ValueOf(&merged).Elem()
+	src := reflect.ValueOf(msg).Elem()
+
+	for i, n := 0, src.NumField(); i != n; i++ {
+		if f := src.Field(i); !isZeroValue(f) && src.Type().Field(i).Name != "Extra" {
+			dst.Field(i).Set(f)
+		}
+	}
+
+	for k, v := range msg.Extra {
+		merged.Extra[k] = v
+	}
+
+	return &merged
+}
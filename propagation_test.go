@@ -0,0 +1,126 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWithUser(t *testing.T) {
+	ctx := WithUser(context.Background(), "A", "B")
+	ctx = WithUser(ctx, "C", "")
+
+	if userId, anonymousId := UserFromContext(ctx); userId != "C" || anonymousId != "B" {
+		t.Errorf("invalid user read from the context: %q %q", userId, anonymousId)
+	}
+}
+
+func TestPropagate(t *testing.T) {
+	base := &Context{
+		Locale: "en-US",
+		IP:     []byte{127, 0, 0, 1},
+		Extra:  map[string]interface{}{"tenant": "acme", "region": "eu"},
+	}
+
+	ctx := WithAnalyticsContext(WithUser(context.Background(), "A", "B"), base)
+
+	msg := propagate(ctx, Track{
+		Event:  "C",
+		UserId: "D",
+		Context: &Context{
+			Locale: "fr-FR",
+			Extra:  map[string]interface{}{"region": "us"},
+		},
+	})
+
+	ref := Track{
+		Event:       "C",
+		UserId:      "D",
+		AnonymousId: "B",
+		Context: &Context{
+			Locale: "fr-FR",
+			IP:     []byte{127, 0, 0, 1},
+			Extra:  map[string]interface{}{"tenant": "acme", "region": "us"},
+		},
+	}
+
+	if !reflect.DeepEqual(msg, ref) {
+		t.Errorf("invalid message:\n- expected %#v\n- found: %#v", ref, msg)
+	}
+
+	// The analytics context stored in the context.Context is shared and must
+	// never be modified.
+	if base.Locale != "en-US" || base.Extra["region"] != "eu" {
+		t.Errorf("the base context was modified: %#v", base)
+	}
+}
+
+func TestEnqueueContext(t *testing.T) {
+	body, server := mockServer()
+	defer server.Close()
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl: server.URL,
+		Logger:       testLogger{t.Logf, t.Logf},
+		BatchSize:    1,
+	})
+	defer client.Close()
+
+	ctx := WithAnalyticsContext(WithUser(context.Background(), "A", "B"), &Context{Locale: "en-US"})
+
+	// The message has no user id, it would be rejected by Enqueue.
+	if err := EnqueueContext(ctx, client, Track{Event: "C"}); err != nil {
+		t.Fatal("enqueuing the message failed:", err)
+	}
+
+	var res struct {
+		Batch []struct {
+			UserId      string  `json:"userId"`
+			AnonymousId string  `json:"anonymousId"`
+			Context     Context `json:"context"`
+		} `json:"batch"`
+	}
+	if err := json.Unmarshal(<-body, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	if m := res.Batch[0]; m.UserId != "A" || m.AnonymousId != "B" || m.Context.Locale != "en-US" {
+		t.Errorf("the context values were not propagated: %#v", m)
+	}
+}
+
+func TestEnqueueContextCanceled(t *testing.T) {
+	// The client has no backend goroutine reading its queue, which is
+	// unbuffered, so the message cannot be queued.
+	c := &client{
+		Config:       makeConfig(Config{}),
+		shards:       []*shard{{msgs: make(chan queuedMessage)}},
+		suppressions: &suppressionList{},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := c.EnqueueContext(ctx, Track{UserId: "A", Event: "B"}); err != context.DeadlineExceeded {
+		t.Error("invalid error returned when the context expired:", err)
+	}
+}
+
+func TestEnqueueContextClient(t *testing.T) {
+	rec := &testRecorder{}
+
+	// The client only implements Enqueue, the values of the context are set
+	// on the message before it is queued.
+	c := struct{ Client }{rec}
+	ctx := WithUser(context.Background(), "A", "B")
+
+	if err := EnqueueContext(ctx, c, Track{Event: "C"}); err != nil {
+		t.Fatal("enqueuing the message failed:", err)
+	}
+
+	if m := rec.msgs[0].(Track); m.UserId != "A" || m.AnonymousId != "B" {
+		t.Errorf("the context values were not propagated: %#v", m)
+	}
+}
@@ -108,10 +108,33 @@ func (p Properties) Set(name string, value interface{}) Properties {
 	return p
 }
 
-// This type represents products in the E-commerce API.
+// This type represents products in the E-commerce API, field names follow the
+// product properties defined by the E-commerce spec. The id of products is
+// serialized as "id", it is reported as the "product_id" property of the
+// single-product events.
 type Product struct {
-	ID    string  `json:"id,omitempty"`
-	SKU   string  `json:"sky,omitempty"`
-	Name  string  `json:"name,omitempty"`
-	Price float64 `json:"price"`
+	ID       string  `json:"id,omitempty"`
+	SKU      string  `json:"sku,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	Price    float64 `json:"price"`
+	Quantity int     `json:"quantity,omitempty"`
+	Brand    string  `json:"brand,omitempty"`
+	Variant  string  `json:"variant,omitempty"`
+	Category string  `json:"category,omitempty"`
+	Coupon   string  `json:"coupon,omitempty"`
+	Position int     `json:"position,omitempty"`
+	URL      string  `json:"url,omitempty"`
+	ImageURL string  `json:"image_url,omitempty"`
+}
+
+func (p Product) Validate() error {
+	if len(p.ID) == 0 && len(p.SKU) == 0 {
+		return FieldError{
+			Type:  "analytics.Product",
+			Name:  "ID",
+			Value: p.ID,
+		}
+	}
+
+	return nil
 }
@@ -0,0 +1,58 @@
+package analytics
+
+// Protocol values are used to select how clients send batches to the data
+// plane, see `Config.Protocol`.
+type Protocol int
+
+const (
+	// Each batch is sent in its own request to the /v1/batch endpoint.
+	ProtocolBatch Protocol = iota
+
+	// Batches are written as newline-delimited JSON to a long-lived request
+	// to the /v1/stream endpoint, which acknowledges each batch, see
+	// `streamUploader`.
+	ProtocolStream
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolBatch:
+		return "batch"
+	case ProtocolStream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// The uploader interface abstracts the protocol used to send serialized batches
+// to the data plane, so the retry, concurrency and circuit breaking logic of
+// clients doesn't depend on it.
+type uploader interface {
+
+	// Sends a batch to the given node of the data plane, returning once the
+	// batch was acknowledged, or with the error that prevented it.
+	upload(b []byte, targetNode string) error
+
+	// Releases the resources held by the uploader, called once all uploads
+	// have returned.
+	close()
+}
+
+// Returns the uploader of the protocol configured on c.
+func newUploader(c *client) uploader {
+	if c.Protocol == ProtocolStream {
+		return newStreamUploader(c)
+	}
+	return batchUploader{c}
+}
+
+// The uploader of `ProtocolBatch`, sending each batch in its own request.
+type batchUploader struct {
+	*client
+}
+
+func (u batchUploader) upload(b []byte, targetNode string) error {
+	return u.client.upload(b, targetNode)
+}
+
+func (u batchUploader) close() {}
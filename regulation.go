@@ -0,0 +1,238 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// This type represents the kind of regulation applied to a user by the
+// regulation endpoint of the data plane.
+type RegulationType string
+
+const (
+	// Stops collecting data for the user, events already stored are kept.
+	RegulationSuppress RegulationType = "suppress"
+
+	// Deletes the data already collected for the user in the destinations.
+	RegulationDelete RegulationType = "delete"
+
+	// Stops collecting data for the user and deletes what was already
+	// collected.
+	RegulationSuppressWithDelete RegulationType = "suppress_with_delete"
+)
+
+// This type represents a regulation request, it is used to ask the data plane
+// to suppress or delete the data of a user, for example when the user asked
+// to be forgotten.
+//
+// When Destinations is empty the regulation applies to all destinations of
+// the source.
+type Regulation struct {
+	UserId       string
+	Type         RegulationType
+	Destinations []string
+}
+
+func (r Regulation) Validate() error {
+	if len(r.UserId) == 0 {
+		return FieldError{
+			Type:  "analytics.Regulation",
+			Name:  "UserId",
+			Value: r.UserId,
+		}
+	}
+
+	switch r.Type {
+	case RegulationSuppress, RegulationDelete, RegulationSuppressWithDelete:
+	default:
+		return FieldError{
+			Type:  "analytics.Regulation",
+			Name:  "Type",
+			Value: r.Type,
+		}
+	}
+
+	return nil
+}
+
+// Returns true if the regulation stops the collection of all events of the
+// user, in which case the client can drop them locally.
+func (r Regulation) suppresses() bool {
+	return r.Type != RegulationDelete && len(r.Destinations) == 0
+}
+
+// This interface is exposed by regulation clients, they send user regulations
+// to the data plane and maintain a local suppression list so the client they
+// were created from stops sending events for suppressed users.
+type RegulationClient interface {
+
+	// Sends the regulation to the data plane, retrying with the retry policy
+	// of the client if the request fails.
+	// Once a suppression covering all destinations succeeded, messages of the
+	// user passed to `Enqueue` are dropped and `ErrUserSuppressed` is
+	// returned.
+	Regulate(Regulation) error
+
+	// Returns true if the user is on the local suppression list.
+	Suppressed(userId string) bool
+
+	// Suppress and Unsuppress add or remove a user from the local suppression
+	// list without contacting the data plane, for example to restore the list
+	// when the application starts.
+	Suppress(userId string)
+	Unsuppress(userId string)
+}
+
+// Instantiate a regulation client that sends requests with the write key,
+// transport, retry policy and logger of the client passed as argument.
+// The function returns an error if the client was not created by this package.
+func NewRegulationClient(c Client) (RegulationClient, error) {
+	cli, ok := c.(*client)
+	if !ok {
+		return nil, fmt.Errorf("analytics.NewRegulationClient: unsupported client type: %T", c)
+	}
+	return &regulationClient{c: cli}, nil
+}
+
+type regulationClient struct {
+	c *client
+}
+
+// This structure represents the body of requests sent to the regulation
+// endpoint.
+type regulationRequest struct {
+	Type         RegulationType   `json:"regulationType"`
+	Destinations []string         `json:"destinationIds,omitempty"`
+	Users        []regulationUser `json:"users"`
+}
+
+type regulationUser struct {
+	UserId string `json:"userId"`
+}
+
+func (r *regulationClient) Regulate(reg Regulation) (err error) {
+	const attempts = 10
+
+	if err = reg.Validate(); err != nil {
+		return
+	}
+
+	b, err := json.Marshal(regulationRequest{
+		Type:         reg.Type,
+		Destinations: reg.Destinations,
+		Users:        []regulationUser{{UserId: reg.UserId}},
+	})
+	if err != nil {
+		return
+	}
+
+	for i := 0; i != attempts; i++ {
+		var retry bool
+		if retry, err = r.c.regulate(b); err == nil || !retry {
+			break
+		}
+
+		if i == attempts-1 {
+			r.c.errorf("regulation of user %s failed after %d attempts", reg.UserId, attempts)
+			break
+		}
+
+		// Wait for either a retry timeout or the client to be closed.
+		select {
+		case <-time.After(r.c.RetryAfter(i)):
+		case <-r.c.quit:
+			r.c.errorf("regulation of user %s aborted because the client was closed", reg.UserId)
+			return
+		}
+	}
+
+	if err == nil && reg.suppresses() {
+		r.c.debugf("user %s added to the suppression list", reg.UserId)
+		r.c.suppressions.add(reg.UserId)
+	}
+	return
+}
+
+func (r *regulationClient) Suppressed(userId string) bool {
+	return r.c.suppressions.contains(userId)
+}
+
+func (r *regulationClient) Suppress(userId string) {
+	r.c.suppressions.add(userId)
+}
+
+func (r *regulationClient) Unsuppress(userId string) {
+	r.c.suppressions.remove(userId)
+}
+
+// Sends a serialized regulation request, the returned boolean is true when
+// the request failed in a way that may succeed if it's retried.
+func (c *client) regulate(b []byte) (retry bool, err error) {
+	url := c.Endpoint + "/v1/regulations"
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		c.errorf("creating request - %s", err)
+		return
+	}
+
+	req.Header.Add("User-Agent", "analytics-go (version: "+Version+")")
+	req.Header.Add("Content-Type", "application/json")
+	req.SetBasicAuth(c.key, "")
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		c.errorf("sending request - %s", err)
+		return true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 300 {
+		c.debugf("response %s", res.Status)
+		return
+	}
+
+	body, _ := io.ReadAll(res.Body)
+	c.logf("response %d %s – %s", res.StatusCode, res.Status, string(body))
+
+	retry = res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	err = fmt.Errorf("%d %s", res.StatusCode, res.Status)
+	return
+}
+
+// This type is a set of user ids for which the client must not send messages.
+// It is safe to use concurrently.
+type suppressionList struct {
+	mutex sync.RWMutex
+	users map[string]struct{}
+}
+
+func (s *suppressionList) add(userId string) {
+	s.mutex.Lock()
+	if s.users == nil {
+		s.users = make(map[string]struct{})
+	}
+	s.users[userId] = struct{}{}
+	s.mutex.Unlock()
+}
+
+func (s *suppressionList) remove(userId string) {
+	s.mutex.Lock()
+	delete(s.users, userId)
+	s.mutex.Unlock()
+}
+
+func (s *suppressionList) contains(userId string) (ok bool) {
+	if len(userId) == 0 {
+		return
+	}
+	s.mutex.RLock()
+	_, ok = s.users[userId]
+	s.mutex.RUnlock()
+	return
+}
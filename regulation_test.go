@@ -0,0 +1,165 @@
+package analytics
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRegulationValidate(t *testing.T) {
+	tests := map[string]struct {
+		reg Regulation
+		err error
+	}{
+		"valid": {
+			Regulation{UserId: "A", Type: RegulationSuppress},
+			nil,
+		},
+		"missing user": {
+			Regulation{Type: RegulationDelete},
+			FieldError{Type: "analytics.Regulation", Name: "UserId", Value: ""},
+		},
+		"invalid type": {
+			Regulation{UserId: "A", Type: "forget"},
+			FieldError{Type: "analytics.Regulation", Name: "Type", Value: RegulationType("forget")},
+		},
+	}
+
+	for name, test := range tests {
+		if err := test.reg.Validate(); err != test.err {
+			t.Errorf("%s: invalid error returned: %v", name, err)
+		}
+	}
+}
+
+func TestRegulationSuppress(t *testing.T) {
+	reqs := make(chan map[string]interface{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/regulations" {
+			return
+		}
+		if key, _, _ := r.BasicAuth(); key != WRITE_KEY {
+			t.Errorf("invalid write key: %s", key)
+		}
+		b, _ := io.ReadAll(r.Body)
+		var v map[string]interface{}
+		json.Unmarshal(b, &v)
+		reqs <- v
+	}))
+	defer server.Close()
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl: server.URL,
+		Logger:       testLogger{t.Logf, t.Logf},
+	})
+	defer client.Close()
+
+	regulations, err := NewRegulationClient(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := regulations.Regulate(Regulation{UserId: "A", Type: RegulationSuppress}); err != nil {
+		t.Fatal("regulating user failed:", err)
+	}
+
+	if v := <-reqs; v["regulationType"] != "suppress" {
+		t.Errorf("invalid regulation request: %v", v)
+	}
+
+	if !regulations.Suppressed("A") {
+		t.Error("the user should have been suppressed")
+	}
+
+	if err := client.Enqueue(Track{UserId: "A", Event: "B"}); err != ErrUserSuppressed {
+		t.Error("invalid error returned when enqueuing a message of a suppressed user:", err)
+	}
+
+	regulations.Unsuppress("A")
+
+	if err := client.Enqueue(Track{UserId: "A", Event: "B"}); err != nil {
+		t.Error("enqueuing a message of an unsuppressed user failed:", err)
+	}
+}
+
+func TestRegulationDeleteDoesNotSuppress(t *testing.T) {
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Logger:    testLogger{t.Logf, t.Logf},
+		Transport: testTransportOK,
+	})
+	defer client.Close()
+
+	regulations, _ := NewRegulationClient(client)
+
+	if err := regulations.Regulate(Regulation{UserId: "A", Type: RegulationDelete}); err != nil {
+		t.Fatal("regulating user failed:", err)
+	}
+
+	if regulations.Suppressed("A") {
+		t.Error("a deletion should not suppress the user")
+	}
+}
+
+func TestRegulationRetry(t *testing.T) {
+	attempts := 0
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Logger: testLogger{t.Logf, t.Logf},
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if attempts++; attempts < 3 {
+				return testTransportError.RoundTrip(r)
+			}
+			return testTransportOK.RoundTrip(r)
+		}),
+		RetryAfter: func(i int) time.Duration { return time.Millisecond },
+	})
+	defer client.Close()
+
+	regulations, _ := NewRegulationClient(client)
+
+	if err := regulations.Regulate(Regulation{UserId: "A", Type: RegulationSuppressWithDelete}); err != nil {
+		t.Fatal("regulating user failed:", err)
+	}
+
+	if attempts != 3 {
+		t.Error("invalid number of attempts:", attempts)
+	}
+}
+
+func TestRegulationBadRequestIsNotRetried(t *testing.T) {
+	attempts := 0
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		Logger: testLogger{t.Logf, t.Logf},
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			return testTransportBadRequest.RoundTrip(r)
+		}),
+		RetryAfter: func(i int) time.Duration { return time.Millisecond },
+	})
+	defer client.Close()
+
+	regulations, _ := NewRegulationClient(client)
+
+	if err := regulations.Regulate(Regulation{UserId: "A", Type: RegulationSuppress}); err == nil {
+		t.Error("no error returned for a rejected regulation")
+	}
+
+	if attempts != 1 {
+		t.Error("invalid number of attempts:", attempts)
+	}
+
+	if regulations.Suppressed("A") {
+		t.Error("a failed regulation should not suppress the user")
+	}
+}
+
+func TestNewRegulationClientCustomType(t *testing.T) {
+	if _, err := NewRegulationClient(nil); err == nil {
+		t.Error("no error returned when creating a regulation client from an unsupported client")
+	}
+}
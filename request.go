@@ -0,0 +1,234 @@
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// This type carries the options used by `ContextFromRequest` to interpret the
+// incoming request.
+type RequestContextOptions struct {
+
+	// The networks of the reverse proxies sitting in front of the application.
+	// When a request comes from one of these networks the `Forwarded` and
+	// `X-Forwarded-*` headers are used to find the client IP and the original
+	// URL of the page, otherwise they are ignored since any client could set
+	// them.
+	TrustedProxies []*net.IPNet
+}
+
+// Builds the context of a message from an incoming HTTP request. The function
+// fills the user agent, IP, locale, page, referrer and campaign of the
+// returned context, as well as the client hints of the browser if it sent
+// any. The application can then set it on the messages it sends while
+// handling the request:
+//
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		client.Enqueue(analytics.Page{
+//			UserId:  "0123456789",
+//			Name:    "Home",
+//			Context: analytics.ContextFromRequest(r, analytics.RequestContextOptions{}),
+//		})
+//	}
+func ContextFromRequest(r *http.Request, opts RequestContextOptions) *Context {
+	ctx := &Context{
+		UserAgent: r.UserAgent(),
+		Locale:    parseAcceptLanguage(r.Header.Get("Accept-Language")),
+	}
+
+	remote := parseHost(r.RemoteAddr)
+	trusted := opts.trusted(remote)
+
+	if trusted {
+		ctx.IP = opts.clientIP(r.Header, remote)
+	} else {
+		ctx.IP = remote
+	}
+
+	if u := requestURL(r, trusted); u != nil {
+		ctx.Page = PageInfo{
+			Path:     u.Path,
+			Referrer: r.Referer(),
+			URL:      u.String(),
+		}
+		if len(u.RawQuery) != 0 {
+			ctx.Page.Search = "?" + u.RawQuery
+		}
+
+		q := u.Query()
+		ctx.Campaign = CampaignInfo{
+			Name:    q.Get("utm_campaign"),
+			Source:  q.Get("utm_source"),
+			Medium:  q.Get("utm_medium"),
+			Term:    q.Get("utm_term"),
+			Content: q.Get("utm_content"),
+		}
+	}
+
+	if referrer := r.Referer(); len(referrer) != 0 {
+		ctx.Referrer = ReferrerInfo{
+			URL: referrer,
+		}
+	}
+
+	if hints := ParseClientHints(r.Header); hints != nil {
+		ctx.Extra = map[string]interface{}{
+			"userAgentData": hints,
+		}
+	}
+
+	return ctx
+}
+
+func (opts RequestContextOptions) trusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range opts.TrustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Walks the chain of forwarded addresses from the closest hop to the farthest
+// and returns the first one that isn't a trusted proxy.
+func (opts RequestContextOptions) clientIP(h http.Header, remote net.IP) net.IP {
+	chain := forwardedFor(h)
+	ip := remote
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := parseHost(chain[i])
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !opts.trusted(hop) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Returns the client addresses listed in the `Forwarded` header, or in the
+// `X-Forwarded-For` header if the former is missing, in the order they were
+// added by the proxies.
+func forwardedFor(h http.Header) (chain []string) {
+	for _, elem := range forwardedElements(h) {
+		if v, ok := elem["for"]; ok {
+			chain = append(chain, v)
+		}
+	}
+
+	if len(chain) != 0 {
+		return
+	}
+
+	for _, line := range h.Values("X-Forwarded-For") {
+		for _, v := range strings.Split(line, ",") {
+			if v = strings.TrimSpace(v); len(v) != 0 {
+				chain = append(chain, v)
+			}
+		}
+	}
+
+	return
+}
+
+// Parses the `Forwarded` header as defined in RFC 7239, each element of the
+// returned slice holds the lower-cased parameters set by one proxy.
+func forwardedElements(h http.Header) (elems []map[string]string) {
+	for _, line := range h.Values("Forwarded") {
+		for _, elem := range strings.Split(line, ",") {
+			params := make(map[string]string)
+
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				params[strings.ToLower(k)] = strings.Trim(v, `"`)
+			}
+
+			elems = append(elems, params)
+		}
+	}
+	return
+}
+
+// Parses an address that may carry a port and be wrapped in square brackets,
+// returning nil if it isn't an IP address.
+func parseHost(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	return net.ParseIP(strings.Trim(addr, "[]"))
+}
+
+// Rebuilds the absolute URL requested by the client, the forwarding headers
+// are only honored when the request comes from a trusted proxy.
+func requestURL(r *http.Request, trusted bool) *url.URL {
+	if r.URL == nil {
+		return nil
+	}
+
+	u := *r.URL
+	u.Scheme = "http"
+	u.Host = r.Host
+
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+
+	if trusted {
+		if elems := forwardedElements(r.Header); len(elems) != 0 {
+			if proto := elems[0]["proto"]; len(proto) != 0 {
+				u.Scheme = proto
+			}
+			if host := elems[0]["host"]; len(host) != 0 {
+				u.Host = host
+			}
+		} else {
+			if proto := r.Header.Get("X-Forwarded-Proto"); len(proto) != 0 {
+				u.Scheme = proto
+			}
+			if host := r.Header.Get("X-Forwarded-Host"); len(host) != 0 {
+				u.Host = host
+			}
+		}
+	}
+
+	return &u
+}
+
+// Returns the language with the highest quality value listed in an
+// `Accept-Language` header, or an empty string if there are none.
+func parseAcceptLanguage(header string) (locale string) {
+	best := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > best {
+			best, locale = q, tag
+		}
+	}
+
+	return
+}
@@ -0,0 +1,137 @@
+package analytics
+
+import (
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+func TestContextFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/pricing?utm_source=newsletter&utm_medium=email&utm_campaign=spring&plan=pro", nil)
+	r.RemoteAddr = "203.0.113.7:52314"
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	r.Header.Set("Accept-Language", "fr-CH, fr;q=0.9, en;q=0.8, *;q=0.5")
+	r.Header.Set("Referer", "https://www.google.com/")
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+
+	ctx := ContextFromRequest(r, RequestContextOptions{})
+
+	ref := &Context{
+		UserAgent: "Mozilla/5.0",
+		Locale:    "fr-CH",
+		// The forwarding headers are ignored when the peer isn't trusted.
+		IP: net.ParseIP("203.0.113.7"),
+		Page: PageInfo{
+			Path:     "/pricing",
+			Referrer: "https://www.google.com/",
+			Search:   "?utm_source=newsletter&utm_medium=email&utm_campaign=spring&plan=pro",
+			URL:      "http://example.com/pricing?utm_source=newsletter&utm_medium=email&utm_campaign=spring&plan=pro",
+		},
+		Referrer: ReferrerInfo{
+			URL: "https://www.google.com/",
+		},
+		Campaign: CampaignInfo{
+			Name:   "spring",
+			Source: "newsletter",
+			Medium: "email",
+		},
+	}
+
+	if !reflect.DeepEqual(ctx, ref) {
+		t.Errorf("invalid context:\n- expected %#v\n- found: %#v", ref, ctx)
+	}
+}
+
+func TestContextFromRequestTrustedProxies(t *testing.T) {
+	opts := RequestContextOptions{
+		TrustedProxies: []*net.IPNet{
+			mustParseCIDR("10.0.0.0/8"),
+		},
+	}
+
+	tests := map[string]struct {
+		remote  string
+		headers map[string]string
+		ip      string
+		url     string
+	}{
+		"x-forwarded-for": {
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1, 203.0.113.7, 10.0.0.2",
+				"X-Forwarded-Proto": "https",
+				"X-Forwarded-Host":  "www.example.com",
+			},
+			ip:  "203.0.113.7",
+			url: "https://www.example.com/",
+		},
+		"forwarded": {
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"Forwarded": `for=198.51.100.1;proto=https;host=www.example.com, for="[2001:db8:cafe::17]:4711"`,
+			},
+			ip:  "2001:db8:cafe::17",
+			url: "https://www.example.com/",
+		},
+		"only proxies": {
+			remote: "10.0.0.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For": "10.0.0.3, 10.0.0.2",
+			},
+			ip:  "10.0.0.3",
+			url: "http://example.com/",
+		},
+		"untrusted peer": {
+			remote: "192.0.2.1:1234",
+			headers: map[string]string{
+				"X-Forwarded-For":   "198.51.100.1",
+				"X-Forwarded-Proto": "https",
+			},
+			ip:  "192.0.2.1",
+			url: "http://example.com/",
+		},
+	}
+
+	for name, test := range tests {
+		r := httptest.NewRequest("GET", "http://example.com/", nil)
+		r.RemoteAddr = test.remote
+		for k, v := range test.headers {
+			r.Header.Set(k, v)
+		}
+
+		ctx := ContextFromRequest(r, opts)
+
+		if !ctx.IP.Equal(net.ParseIP(test.ip)) {
+			t.Errorf("%s: invalid ip: %s", name, ctx.IP)
+		}
+
+		if ctx.Page.URL != test.url {
+			t.Errorf("%s: invalid page url: %s", name, ctx.Page.URL)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"*":                       "",
+		"en-US":                   "en-US",
+		"en;q=0.5, de-DE;q=0.8":   "de-DE",
+		"da, en-gb;q=0.8, en;q=1": "da",
+	}
+
+	for header, locale := range tests {
+		if s := parseAcceptLanguage(header); s != locale {
+			t.Errorf("%q: invalid locale: %q", header, s)
+		}
+	}
+}
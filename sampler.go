@@ -0,0 +1,199 @@
+package analytics
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Instances of this type define how messages of a given event name or type are
+// sampled and rate limited by a `Sampler`.
+type SamplingRule struct {
+
+	// The fraction of users whose messages are kept, between 0 and 1. Users are
+	// selected by hashing their user id (or anonymous id) so they're either
+	// consistently in or out of the sample.
+	// The zero-value keeps all messages.
+	Rate float64
+
+	// The maximum number of messages per second that are kept, the messages
+	// exceeding the limit are dropped.
+	// The zero-value disables rate limiting.
+	Limit float64
+
+	// The number of messages that may be kept at once when the rate limit was
+	// not reached for a while, defaults to the limit rounded up.
+	Burst int
+}
+
+// A Sampler is used by clients to drop a fraction of high-volume messages
+// before they are queued, see `Config.Sampler`.
+//
+// The rule of a message is looked up in `Events` first, using the event name
+// of tracks and the name of pages and screens, then in `Types` using the
+// message type ("track", "page", ...). Messages that match no rule are always
+// kept.
+//
+// When a message is kept by a rule that has a sampling rate, the rate is set
+// on the message context as "sampleRate" so downstream consumers can reweight
+// the data.
+type Sampler struct {
+	Events map[string]SamplingRule
+	Types  map[string]SamplingRule
+
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// Verifies that the rules of the sampler are set to valid values.
+func (s *Sampler) validate() error {
+	check := func(field string, rule SamplingRule) error {
+		switch {
+		case rule.Rate < 0 || rule.Rate > 1:
+			return ConfigError{
+				Reason: "sampling rates must be between 0 and 1",
+				Field:  field + ".Rate",
+				Value:  rule.Rate,
+			}
+		case rule.Limit < 0:
+			return ConfigError{
+				Reason: "negative rate limits are not supported",
+				Field:  field + ".Limit",
+				Value:  rule.Limit,
+			}
+		case rule.Burst < 0:
+			return ConfigError{
+				Reason: "negative bursts are not supported",
+				Field:  field + ".Burst",
+				Value:  rule.Burst,
+			}
+		}
+		return nil
+	}
+
+	for name, rule := range s.Events {
+		if err := check(fmt.Sprintf("Sampler.Events[%q]", name), rule); err != nil {
+			return err
+		}
+	}
+
+	for name, rule := range s.Types {
+		if err := check(fmt.Sprintf("Sampler.Types[%q]", name), rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decides whether msg is kept, returning the sampling rate that was applied or
+// `ErrSampled` or `ErrRateLimited` if the message must be dropped.
+func (s *Sampler) sample(msg Message, now time.Time) (float64, error) {
+	key, rule, ok := s.rule(msg)
+	if !ok {
+		return 1, nil
+	}
+
+	rate := rule.Rate
+	if rate == 0 {
+		rate = 1
+	}
+
+	if rate < 1 && !sampled(makeString(messageUserId(msg), messageAnonymousId(msg)), rate) {
+		return rate, ErrSampled
+	}
+
+	if rule.Limit != 0 && !s.bucket(key, rule).take(now) {
+		return rate, ErrRateLimited
+	}
+
+	return rate, nil
+}
+
+func (s *Sampler) rule(msg Message) (key string, rule SamplingRule, ok bool) {
+	if name := messageEventName(msg); len(name) != 0 {
+		if rule, ok = s.Events[name]; ok {
+			return "event:" + name, rule, true
+		}
+	}
+
+	typ := messageTypeName(msg)
+	if rule, ok = s.Types[typ]; ok {
+		return "type:" + typ, rule, true
+	}
+
+	return
+}
+
+func (s *Sampler) bucket(key string, rule SamplingRule) *tokenBucket {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.buckets == nil {
+		s.buckets = make(map[string]*tokenBucket)
+	}
+
+	b := s.buckets[key]
+	if b == nil {
+		b = newTokenBucket(rule.Limit, rule.Burst)
+		s.buckets[key] = b
+	}
+	return b
+}
+
+// Returns true if the messages of the user identified by id are part of the
+// sample, ids are hashed so the outcome is stable. Messages without ids are
+// sampled randomly.
+func sampled(id string, rate float64) bool {
+	if len(id) == 0 {
+		return rand.Float64() < rate
+	}
+	return float64(crc32.ChecksumIEEE([]byte(id)))/(1<<32) < rate
+}
+
+// This type is a token bucket, it is refilled at a constant rate up to a fixed
+// capacity and each kept message takes one token from it.
+type tokenBucket struct {
+	mutex    sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	capacity := float64(burst)
+	if capacity == 0 {
+		capacity = math.Ceil(rate)
+	}
+	return &tokenBucket{
+		rate:     rate,
+		capacity: capacity,
+		tokens:   capacity,
+	}
+}
+
+func (b *tokenBucket) take(now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if !b.last.IsZero() {
+		if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+			b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		}
+	}
+
+	if now.After(b.last) {
+		b.last = now
+	}
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+	return true
+}
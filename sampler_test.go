@@ -0,0 +1,169 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSamplerDeterministic(t *testing.T) {
+	s := &Sampler{
+		Types: map[string]SamplingRule{"page": {Rate: 0.5}},
+	}
+	now := time.Now()
+
+	kept := 0
+	for i := 0; i != 1000; i++ {
+		msg := Page{UserId: fmt.Sprint("user-", i), Name: "Heartbeat"}
+
+		_, err1 := s.sample(msg, now)
+		_, err2 := s.sample(msg, now)
+
+		if err1 != err2 {
+			t.Fatalf("the same user was sampled inconsistently: %v != %v", err1, err2)
+		}
+
+		if err1 == nil {
+			kept++
+		}
+	}
+
+	if kept < 400 || kept > 600 {
+		t.Error("invalid number of messages kept by the sampler:", kept)
+	}
+}
+
+func TestSamplerEventTakesPrecedence(t *testing.T) {
+	s := &Sampler{
+		Events: map[string]SamplingRule{"Download": {Rate: 1}},
+		Types:  map[string]SamplingRule{"track": {Rate: 1e-9}},
+	}
+	now := time.Now()
+
+	if _, err := s.sample(Track{UserId: "A", Event: "Download"}, now); err != nil {
+		t.Error("the event rule was not applied:", err)
+	}
+
+	if _, err := s.sample(Track{UserId: "A", Event: "Upload"}, now); err != ErrSampled {
+		t.Error("the type rule was not applied:", err)
+	}
+
+	if rate, err := s.sample(Identify{UserId: "A"}, now); err != nil || rate != 1 {
+		t.Error("messages matching no rule should be kept:", rate, err)
+	}
+}
+
+func TestSamplerRateLimit(t *testing.T) {
+	s := &Sampler{
+		Events: map[string]SamplingRule{"Heartbeat": {Limit: 2}},
+	}
+	now := time.Now()
+	msg := Page{AnonymousId: "A", Name: "Heartbeat"}
+
+	for i := 0; i != 2; i++ {
+		if _, err := s.sample(msg, now); err != nil {
+			t.Fatal("the message should be kept by the rate limiter:", err)
+		}
+	}
+
+	if _, err := s.sample(msg, now); err != ErrRateLimited {
+		t.Error("the rate limit was not applied:", err)
+	}
+
+	if _, err := s.sample(msg, now.Add(500*time.Millisecond)); err != nil {
+		t.Error("the bucket was not refilled:", err)
+	}
+}
+
+func TestSamplerInvalidConfig(t *testing.T) {
+	tests := map[string]*Sampler{
+		"rate":  {Events: map[string]SamplingRule{"A": {Rate: 2}}},
+		"limit": {Types: map[string]SamplingRule{"track": {Limit: -1}}},
+		"burst": {Types: map[string]SamplingRule{"track": {Burst: -1}}},
+	}
+
+	for name, s := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewWithConfig(WRITE_KEY, Config{Sampler: s}); err == nil {
+				t.Error("the invalid sampler was not rejected")
+			}
+		})
+	}
+}
+
+func TestEnqueueSampled(t *testing.T) {
+	metrics := &testMetrics{}
+	sampler := &Sampler{
+		Events: map[string]SamplingRule{
+			"Heartbeat": {Rate: 1e-9},
+			"Download":  {Rate: 0.999999999},
+		},
+	}
+
+	c := &client{
+		Config: makeConfig(Config{
+			Sampler: sampler,
+			Metrics: metrics,
+		}),
+		shards:       newShards(1),
+		suppressions: &suppressionList{},
+	}
+
+	if err := c.Enqueue(Page{UserId: "A", Name: "Heartbeat"}); err != ErrSampled {
+		t.Error("invalid error returned for a sampled message:", err)
+	}
+
+	if n := metrics.count(MetricMessagesSampled); n != 1 {
+		t.Error("invalid number of sampled messages reported:", n)
+	}
+
+	if err := c.Enqueue(Track{UserId: "A", Event: "Download"}); err != nil {
+		t.Fatal("queuing the message failed:", err)
+	}
+
+	msg := (<-c.shards[0].msgs).msg.(Track)
+	if rate := msg.Context.Extra["sampleRate"]; rate != 0.999999999 {
+		t.Error("the sample rate was not set on the message context:", rate)
+	}
+}
+
+func TestEnqueueSampledSharedContext(t *testing.T) {
+	c := &client{
+		Config: makeConfig(Config{
+			Sampler: &Sampler{
+				Events: map[string]SamplingRule{"Download": {Rate: 0.999999999}},
+			},
+		}),
+		shards:       newShards(1),
+		suppressions: &suppressionList{},
+	}
+
+	// Applications commonly reuse the same context across messages.
+	ctx := &Context{Extra: map[string]interface{}{"source": "test"}}
+
+	if err := c.Enqueue(Track{UserId: "A", Event: "Download", Context: ctx}); err != nil {
+		t.Fatal("queuing the message failed:", err)
+	}
+	if err := c.Enqueue(Track{UserId: "A", Event: "Upload", Context: ctx}); err != nil {
+		t.Fatal("queuing the message failed:", err)
+	}
+
+	first := (<-c.shards[0].msgs).msg.(Track)
+	second := (<-c.shards[0].msgs).msg.(Track)
+
+	if rate := first.Context.Extra["sampleRate"]; rate != 0.999999999 {
+		t.Error("the sample rate was not set on the message context:", rate)
+	}
+
+	if rate, ok := second.Context.Extra["sampleRate"]; ok {
+		t.Error("the sample rate leaked to the next message sharing the context:", rate)
+	}
+
+	if _, ok := ctx.Extra["sampleRate"]; ok || len(ctx.Extra) != 1 {
+		t.Error("the context of the application was modified:", ctx.Extra)
+	}
+
+	if second.Context.Extra["source"] != "test" {
+		t.Error("the extra fields of the context were not preserved:", second.Context.Extra)
+	}
+}
@@ -0,0 +1,65 @@
+package analytics
+
+// Clients split their batching work in shards, each served by its own
+// goroutine which marshals the messages and maintains the batches of the shard.
+// Messages are assigned to shards by `UserId`, or by `AnonymousId` when they
+// have no user id, so the messages of a user are sent in the order they were
+// queued in.
+//
+// The messages of a user queued before its `UserId` is known are assigned by
+// anonymous id, they may be on a different shard than the messages queued
+// after an identify call and their relative order is not preserved.
+type shard struct {
+
+	// This channel is where the `Enqueue` method writes messages so they can be
+	// picked up and pushed by the backend goroutine of the shard, taking care
+	// of applying the batching rules.
+	msgs chan queuedMessage
+
+	// Same as `msgs` but for high priority messages, the backend goroutine
+	// always picks up messages from this channel first.
+	high chan queuedMessage
+}
+
+func newShards(n int) []*shard {
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			msgs: make(chan queuedMessage, 100),
+			high: make(chan queuedMessage, 100),
+		}
+	}
+	return shards
+}
+
+// Returns the shard that msg is queued to.
+func (c *client) shard(msg Message) *shard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+
+	// Anonymous ids are generated for the messages that don't have one, so
+	// the user id takes precedence to keep the messages of a user together.
+	id := messageUserId(msg)
+	if len(id) == 0 {
+		id = messageAnonymousId(msg)
+	}
+
+	// Messages that have no user are spread evenly across shards.
+	if len(id) == 0 {
+		return c.shards[c.next.Add(1)%uint32(len(c.shards))]
+	}
+
+	return c.shards[hashId(id)%uint32(len(c.shards))]
+}
+
+// Returns the 32 bits FNV-1a hash of id, computed on the string directly so
+// assigning a message to its shard doesn't allocate.
+func hashId(id string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(id); i++ {
+		h ^= uint32(id[i])
+		h *= 16777619
+	}
+	return h
+}
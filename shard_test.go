@@ -0,0 +1,175 @@
+package analytics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientShard(t *testing.T) {
+	c := &client{shards: newShards(4)}
+
+	for _, id := range []string{"A", "B", "C", "D", "E"} {
+		s := c.shard(Track{UserId: id, Event: "1"})
+
+		if c.shard(Page{UserId: id, Name: "2"}) != s {
+			t.Errorf("the messages of user %s were assigned to different shards", id)
+		}
+	}
+
+	counts := make(map[*shard]int)
+	for i := 0; i != 8; i++ {
+		counts[c.shard(Alias{PreviousId: "A"})]++
+	}
+
+	for i, s := range c.shards {
+		if counts[s] != 2 {
+			t.Errorf("messages without users should be spread evenly, shard %d has %d messages", i, counts[s])
+		}
+	}
+}
+
+func TestClientShardPrepared(t *testing.T) {
+	c := &client{
+		Config:       makeConfig(Config{}),
+		shards:       newShards(4),
+		suppressions: &suppressionList{},
+	}
+
+	// Messages without anonymous id are given a random one when prepared, they
+	// must still be assigned to the shard of their user.
+	var s *shard
+	for i := 0; i != 20; i++ {
+		msg, err := c.prepare(context.Background(), Track{UserId: "A", Event: strconv.Itoa(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			s = c.shard(msg)
+		} else if c.shard(msg) != s {
+			t.Fatal("the messages of a user were assigned to different shards")
+		}
+	}
+}
+
+func TestClientShards(t *testing.T) {
+	var mutex sync.Mutex
+	received := make(map[string][]int)
+
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		var b struct {
+			Batch []struct {
+				UserId     string `json:"userId"`
+				Properties struct {
+					Seq int `json:"seq"`
+				} `json:"properties"`
+			} `json:"batch"`
+		}
+		if err := json.Unmarshal(gunzip(t, r.Body), &b); err != nil {
+			t.Error(err)
+		}
+
+		// Batches are sent concurrently, the order of the messages of a user
+		// is only preserved within a batch.
+		last := make(map[string]int)
+		for _, m := range b.Batch {
+			if seq, ok := last[m.UserId]; ok && seq >= m.Properties.Seq {
+				t.Errorf("the messages of user %s were reordered: %d, %d", m.UserId, seq, m.Properties.Seq)
+			}
+			last[m.UserId] = m.Properties.Seq
+		}
+
+		mutex.Lock()
+		for _, m := range b.Batch {
+			received[m.UserId] = append(received[m.UserId], m.Properties.Seq)
+		}
+		mutex.Unlock()
+		return testTransportOK.RoundTrip(r)
+	})
+
+	client, err := NewWithConfig(WRITE_KEY, Config{
+		Shards:         4,
+		Logger:         testLogger{t.Logf, t.Logf},
+		Transport:      transport,
+		NoProxySupport: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for seq := 0; seq != 100; seq++ {
+		for user := 0; user != 10; user++ {
+			client.Enqueue(Track{
+				UserId:     strconv.Itoa(user),
+				Event:      "Download",
+				Properties: Properties{"seq": seq},
+			})
+		}
+	}
+	client.Close()
+
+	for user := 0; user != 10; user++ {
+		seqs := received[strconv.Itoa(user)]
+		if len(seqs) != 100 {
+			t.Errorf("invalid number of messages received for user %d: %d", user, len(seqs))
+			continue
+		}
+
+		seen := make(map[int]bool)
+		for _, seq := range seqs {
+			seen[seq] = true
+		}
+		if len(seen) != 100 {
+			t.Errorf("duplicate messages received for user %d", user)
+		}
+	}
+}
+
+func TestShardsConfigError(t *testing.T) {
+	if _, err := NewWithConfig(WRITE_KEY, Config{Shards: -1}); err == nil {
+		t.Error("a negative number of shards should be rejected")
+	}
+}
+
+func BenchmarkEnqueue(b *testing.B) {
+	// The baseline is the default configuration, where all messages go
+	// through the single channel of one batching goroutine.
+	for _, shards := range []int{0, 2, 4, 8} {
+		name := fmt.Sprintf("shards=%d", shards)
+		if shards == 0 {
+			name = "baseline"
+		}
+
+		b.Run(name, func(b *testing.B) {
+			client, _ := NewWithConfig(WRITE_KEY, Config{
+				Shards:         shards,
+				Logger:         testLogger{b.Logf, b.Logf},
+				Transport:      testTransportOK,
+				NoProxySupport: true,
+			})
+			defer client.Close()
+
+			track := benchmarkTrack()
+			var next atomic.Int32
+			b.ReportAllocs()
+
+			// Each producer queues the messages of its own user.
+			b.RunParallel(func(pb *testing.PB) {
+				msg := track
+				msg.UserId = strconv.Itoa(int(next.Add(1)))
+
+				for pb.Next() {
+					if err := client.Enqueue(msg); err != nil {
+						b.Error(err)
+					}
+				}
+			})
+		})
+	}
+}
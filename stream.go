@@ -0,0 +1,311 @@
+package analytics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The uploader of `ProtocolStream`.
+//
+// Batches are written to a long-lived POST request to the /v1/stream endpoint,
+// one per line, as the JSON object of the batch with an additional "id" field.
+// The data plane writes one acknowledgement per batch to the response body,
+// also as newline-delimited JSON:
+//
+//	{"id":1,"status":200}
+//	{"id":2,"status":503,"error":"..."}
+//
+// The status has the same meaning as the status of /v1/batch responses, so a
+// batch is retried the same way with both protocols. A stream is opened per
+// node of the data plane the first time a batch is sent to it, and opened
+// again after it ended. Streams are sent uncompressed, and require the data
+// plane to read the request while writing the response, as HTTP/2 servers do.
+type streamUploader struct {
+	*client
+
+	// The client used to open streams, it doesn't have a timeout since streams
+	// stay open for the lifetime of the client. Batches waiting for their
+	// acknowledgement are bounded by `Config.Timeout` instead.
+	http http.Client
+
+	mutex   sync.Mutex
+	streams map[string]*stream
+	nextId  uint64
+}
+
+func newStreamUploader(c *client) *streamUploader {
+	return &streamUploader{
+		client:  c,
+		http:    http.Client{Transport: c.Transport},
+		streams: make(map[string]*stream),
+	}
+}
+
+// The state of a stream to one node of the data plane.
+type stream struct {
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	cancel context.CancelFunc
+
+	// Serializes the frames written to the stream.
+	write sync.Mutex
+
+	// Protects the fields below.
+	mutex   sync.Mutex
+	pending map[uint64]chan error
+	body    io.Closer
+	err     error
+
+	// Closed when the stream ended.
+	done chan struct{}
+}
+
+// The acknowledgement of a batch sent on a stream.
+type streamAck struct {
+	Id     uint64 `json:"id"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (u *streamUploader) upload(b []byte, targetNode string) error {
+	s, id := u.stream(targetNode)
+
+	// The deadline covers writing the batch as well as waiting for its
+	// acknowledgement, a stalled stream is ended so the writes blocked on it
+	// return and the next batch opens a new one.
+	deadline := time.AfterFunc(u.Timeout, func() { s.end(ErrStreamClosed) })
+	defer deadline.Stop()
+
+	ack, err := s.register(id)
+	if err != nil {
+		u.errorf("sending batch on stream - %s", err)
+		return err
+	}
+
+	frame := make([]byte, 0, len(b)+32)
+	frame = append(frame, `{"id":`...)
+	frame = strconv.AppendUint(frame, id, 10)
+	frame = append(frame, ',')
+	frame = append(frame, b[1:]...)
+	frame = append(frame, '\n')
+
+	s.write.Lock()
+	_, err = s.writer.Write(frame)
+	s.write.Unlock()
+
+	if err != nil {
+		s.end(err)
+	}
+
+	if err = <-ack; err != nil {
+		u.errorf("batch not acknowledged - %s", err)
+	}
+	return err
+}
+
+// Returns the stream of targetNode, opening it if needed, and the id of the
+// next batch.
+func (u *streamUploader) stream(targetNode string) (*stream, uint64) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	s := u.streams[targetNode]
+	if s == nil || s.ended() {
+		s = u.open(targetNode)
+		u.streams[targetNode] = s
+	}
+	u.nextId++
+	return s, u.nextId
+}
+
+// Opens a stream to targetNode, the request is sent in the background.
+func (u *streamUploader) open(targetNode string) *stream {
+	ctx, cancel := context.WithCancel(context.Background())
+	reader, writer := io.Pipe()
+
+	s := &stream{
+		reader:  reader,
+		writer:  writer,
+		cancel:  cancel,
+		pending: make(map[uint64]chan error),
+		done:    make(chan struct{}),
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.Endpoint+"/v1/stream", reader)
+	if err != nil {
+		s.end(err)
+		return s
+	}
+
+	req.Header.Add("User-Agent", "analytics-go (version: "+Version+")")
+	req.Header.Add("Content-Type", "application/x-ndjson")
+	if !u.NoProxySupport {
+		req.Header.Add("RS-targetNode", targetNode)
+		req.Header.Add("RS-nodeCount", strconv.Itoa(u.totalNodes))
+		req.Header.Add("RS-userAgent", "serverSDK")
+	}
+	req.SetBasicAuth(u.key, "")
+
+	go u.run(s, req)
+	return s
+}
+
+// Sends the request of the stream and dispatches the acknowledgements until
+// the stream ends.
+func (u *streamUploader) run(s *stream, req *http.Request) {
+	res, err := u.http.Do(req)
+	if err != nil {
+		s.end(err)
+		return
+	}
+	defer res.Body.Close()
+
+	// Canceling the request doesn't always abort a stream whose body is
+	// blocked on flow control, the response body is closed as well when the
+	// stream ends.
+	if !s.attach(res.Body) {
+		return
+	}
+
+	if res.StatusCode >= 300 {
+		s.end(u.report(res))
+		return
+	}
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		var ack streamAck
+		if err := json.Unmarshal(scanner.Bytes(), &ack); err != nil {
+			s.end(err)
+			return
+		}
+		if err := s.acknowledge(ack); err != nil {
+			s.end(err)
+			return
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		s.end(err)
+		return
+	}
+	s.end(ErrStreamClosed)
+}
+
+// Ends the streams, waiting up to `Config.Timeout` for the data plane to
+// acknowledge the batches and close them. The streams still open after the
+// timeout are ended.
+func (u *streamUploader) close() {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+
+	for _, s := range u.streams {
+		s.writer.Close()
+	}
+
+	timer := time.NewTimer(u.Timeout)
+	defer timer.Stop()
+
+	for _, s := range u.streams {
+		select {
+		case <-s.done:
+		case <-timer.C:
+			for _, s := range u.streams {
+				s.end(ErrStreamClosed)
+			}
+			return
+		}
+	}
+}
+
+// Registers a batch waiting for its acknowledgement, an error is returned if
+// the stream already ended.
+func (s *stream) register(id uint64) (chan error, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	ack := make(chan error, 1)
+	s.pending[id] = ack
+	return ack, nil
+}
+
+// Reports the acknowledgement to the batch waiting for it, an error is
+// returned if the status is invalid.
+func (s *stream) acknowledge(ack streamAck) error {
+	if ack.Status < 200 || ack.Status > 599 {
+		return fmt.Errorf("invalid status in the acknowledgement of batch %d: %d", ack.Id, ack.Status)
+	}
+
+	s.mutex.Lock()
+	pending, ok := s.pending[ack.Id]
+	delete(s.pending, ack.Id)
+	s.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case ack.Status < 300:
+		pending <- nil
+	case ack.Status == 451:
+		pending <- statusError{code: ack.Status}
+	default:
+		pending <- statusError{code: ack.Status, status: ack.Error}
+	}
+	return nil
+}
+
+// Sets the response body of the stream, returns false if the stream already
+// ended.
+func (s *stream) attach(body io.Closer) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return false
+	}
+	s.body = body
+	return true
+}
+
+func (s *stream) ended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.err != nil
+}
+
+// Ends the stream, the batches waiting for their acknowledgement fail with
+// err. Only the first call has an effect.
+func (s *stream) end(err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	s.err = err
+
+	for id, pending := range s.pending {
+		pending <- err
+		delete(s.pending, id)
+	}
+
+	s.cancel()
+	s.reader.CloseWithError(err)
+	if s.body != nil {
+		s.body.Close()
+	}
+	close(s.done)
+}
@@ -0,0 +1,304 @@
+package analytics
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Starts an HTTP/2 server acknowledging the batches written to its streams with
+// the status returned by the ack function, which receives the number of
+// messages of the batch. The server ends the stream after the batch when the
+// function returns false.
+func testStreamServer(t *testing.T, streams *atomic.Int32, ack func(n int) (status int, keep bool)) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/stream" || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("unexpected request: %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		streams.Add(1)
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		enc := json.NewEncoder(w)
+		scanner := bufio.NewScanner(r.Body)
+		for scanner.Scan() {
+			var frame struct {
+				Id    uint64            `json:"id"`
+				Batch []json.RawMessage `json:"batch"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+				t.Error(err)
+				return
+			}
+
+			status, keep := ack(len(frame.Batch))
+			enc.Encode(streamAck{Id: frame.Id, Status: status})
+			w.(http.Flusher).Flush()
+
+			if !keep {
+				return
+			}
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server
+}
+
+func testStreamClient(t *testing.T, server *httptest.Server, callback Callback) Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	client, err := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl:   server.URL,
+		Protocol:       ProtocolStream,
+		BatchSize:      1,
+		TLSConfig:      &tls.Config{RootCAs: roots},
+		RetryAfter:     func(int) time.Duration { return time.Millisecond },
+		Callback:       callback,
+		Logger:         testLogger{t.Logf, t.Logf},
+		NoProxySupport: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestStreamProtocol(t *testing.T) {
+	var streams, batches atomic.Int32
+
+	server := testStreamServer(t, &streams, func(n int) (int, bool) {
+		if n != 1 {
+			t.Error("invalid number of messages in the batch:", n)
+		}
+		batches.Add(1)
+		return http.StatusOK, true
+	})
+	defer server.Close()
+
+	var mutex sync.Mutex
+	var sent int
+
+	client := testStreamClient(t, server, testCallback{
+		success: func(m Message) {
+			mutex.Lock()
+			sent++
+			mutex.Unlock()
+		},
+		failure: func(m Message, err error) { t.Error("unexpected failure:", err) },
+	})
+
+	for i := 0; i != 3; i++ {
+		client.Enqueue(Track{UserId: "A", Event: "B"})
+	}
+	client.Close()
+
+	if sent != 3 || batches.Load() != 3 {
+		t.Errorf("invalid number of messages sent: %d, batches received: %d", sent, batches.Load())
+	}
+
+	if n := streams.Load(); n != 1 {
+		t.Error("the batches should have been sent on a single stream:", n)
+	}
+}
+
+func TestStreamProtocolRetry(t *testing.T) {
+	var streams atomic.Int32
+
+	// The first batch is rejected and its stream ended, the batch is retried
+	// on a new stream.
+	server := testStreamServer(t, &streams, func(n int) (int, bool) {
+		if streams.Load() == 1 {
+			return http.StatusServiceUnavailable, false
+		}
+		return http.StatusOK, true
+	})
+	defer server.Close()
+
+	sent := make(chan Message, 1)
+
+	client := testStreamClient(t, server, testCallback{
+		success: func(m Message) { sent <- m },
+		failure: func(m Message, err error) { t.Error("unexpected failure:", err) },
+	})
+
+	defer client.Close()
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Error("the message should have been sent")
+	}
+
+	if n := streams.Load(); n != 2 {
+		t.Error("the batch should have been retried on a new stream:", n)
+	}
+}
+
+func TestStreamAcknowledge(t *testing.T) {
+	tests := map[string]struct {
+		ack    streamAck
+		failed bool
+	}{
+		"success":     {streamAck{Status: 200}, false},
+		"unavailable": {streamAck{Status: 503, Error: "unavailable"}, true},
+		"rejected":    {streamAck{Status: 400, Error: "invalid batch"}, false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			s := &stream{pending: make(map[uint64]chan error)}
+			ack, _ := s.register(test.ack.Id)
+
+			if err := s.acknowledge(test.ack); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := <-ack; (err != nil) != (test.ack.Status >= 300) || uploadFailed(err) != test.failed {
+				t.Error("invalid acknowledgement error:", err)
+			}
+		})
+	}
+}
+
+func TestStreamAcknowledgeInvalidStatus(t *testing.T) {
+	for _, status := range []int{0, 100, 600} {
+		s := &stream{pending: make(map[uint64]chan error)}
+		s.register(1)
+
+		if err := s.acknowledge(streamAck{Id: 1, Status: status}); err == nil {
+			t.Error("an acknowledgement with an invalid status should be rejected:", status)
+		}
+
+		if len(s.pending) != 1 {
+			t.Error("the batch should still be waiting for its acknowledgement:", status)
+		}
+	}
+}
+
+// Starts an HTTP/2 server that accepts streams but never acknowledges batches
+// or closes the streams until the test ends. The request bodies are read when
+// read is true.
+func testStalledStreamServer(t *testing.T, read bool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		if read {
+			io.Copy(io.Discard, r.Body)
+		}
+		<-r.Context().Done()
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	return server
+}
+
+func testStreamUploader(t *testing.T, server *httptest.Server, timeout time.Duration) *streamUploader {
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	config := makeConfig(Config{
+		DataPlaneUrl:   server.URL,
+		Timeout:        timeout,
+		TLSConfig:      &tls.Config{RootCAs: roots},
+		Logger:         testLogger{t.Logf, t.Logf},
+		NoProxySupport: true,
+	})
+	return newStreamUploader(&client{Config: config, key: WRITE_KEY})
+}
+
+func TestStreamAcknowledgeTimeout(t *testing.T) {
+	server := testStalledStreamServer(t, true)
+	defer server.Close()
+
+	u := testStreamUploader(t, server, 100*time.Millisecond)
+	defer u.close()
+
+	if err := u.upload([]byte(`{"batch":[]}`), "0"); err != ErrStreamClosed {
+		t.Error("invalid error returned when the batch is not acknowledged:", err)
+	}
+}
+
+func TestStreamWriteTimeout(t *testing.T) {
+	server := testStalledStreamServer(t, false)
+	defer server.Close()
+
+	u := testStreamUploader(t, server, 100*time.Millisecond)
+	defer u.close()
+
+	// The batch exceeds the flow control window of the stream, the write
+	// blocks since the server doesn't read it.
+	b := []byte(`{"batch":[],"padding":"` + strings.Repeat("x", 16<<20) + `"}`)
+
+	done := make(chan error, 2)
+	for i := 0; i != 2; i++ {
+		go func() { done <- u.upload(b, "0") }()
+	}
+
+	for i := 0; i != 2; i++ {
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("the upload should have failed")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the upload blocked on the stalled stream")
+		}
+	}
+}
+
+func TestStreamUploaderClose(t *testing.T) {
+	server := testStalledStreamServer(t, true)
+	defer server.Close()
+
+	u := testStreamUploader(t, server, 100*time.Millisecond)
+
+	for _, node := range []string{"0", "1", "2"} {
+		u.streams[node] = u.open(node)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		u.close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing the uploader blocked on streams that were never closed")
+	}
+
+	for node, s := range u.streams {
+		if !s.ended() {
+			t.Error("the stream should have been ended:", node)
+		}
+	}
+}
+
+func TestProtocolConfigError(t *testing.T) {
+	for _, protocol := range []Protocol{-1, ProtocolStream + 1} {
+		_, err := NewWithConfig(WRITE_KEY, Config{Protocol: protocol})
+
+		if e, ok := err.(ConfigError); !ok || e.Field != "Protocol" {
+			t.Error("invalid configuration error:", err)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// This constant sets the default timeout of the requests sent by client
+// instances if none was explicitly set.
+const DefaultTimeout = 10 * time.Second
+
+// This constant sets the default number of idle connections to the data plane
+// kept open by client instances if none was explicitly set.
+const DefaultMaxIdleConns = 100
+
+// Returns the transport used by clients when the configuration doesn't have
+// one.
+//
+// Unlike `http.DefaultTransport`, which keeps only two idle connections per
+// host, the transport keeps enough connections open to the data plane for the
+// concurrent uploads of the client to reuse them. HTTP/2 is negotiated even
+// when a custom TLS configuration is set, which would otherwise disable it.
+func newTransport(c Config) *http.Transport {
+	proxy := c.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	// The transport adds the protocols it negotiates to the TLS configuration,
+	// it is cloned so the configuration of the application is left untouched.
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		TLSClientConfig:       c.TLSConfig.Clone(),
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConns,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// Returns the transport that makeConfig created when the configuration passed
+// by the application had none, or nil if the application set its own transport,
+// which may be shared and must not be closed.
+func ownedTransport(config Config, made Config) *http.Transport {
+	if config.Transport != nil {
+		return nil
+	}
+	transport, _ := made.Transport.(*http.Transport)
+	return transport
+}
+
+func makeHttpClient(transport http.RoundTripper, timeout time.Duration) http.Client {
+	httpClient := http.Client{
+		Transport: transport,
+	}
+	if supportsTimeout(transport) {
+		httpClient.Timeout = timeout
+	}
+	return httpClient
+}
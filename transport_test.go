@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestTransportHTTP2(t *testing.T) {
+	protos := make(chan string, 10)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/batch" {
+			protos <- r.Proto
+		}
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	proxied := false
+	config := &tls.Config{RootCAs: roots}
+
+	client, err := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl:   server.URL,
+		Logger:         testLogger{t.Logf, t.Logf},
+		NoProxySupport: true,
+		TLSConfig:      config,
+		Proxy: func(r *http.Request) (*url.URL, error) {
+			proxied = true
+			return nil, nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+	client.Close()
+
+	if proto := <-protos; proto != "HTTP/2.0" {
+		t.Error("the request should have been sent over HTTP/2:", proto)
+	}
+
+	if !proxied {
+		t.Error("the proxy function should have been called")
+	}
+
+	if len(config.NextProtos) != 0 {
+		t.Error("the TLS configuration of the application should not be modified:", config.NextProtos)
+	}
+}
+
+func TestTransportTimeout(t *testing.T) {
+	done := make(chan struct{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	config := makeConfig(Config{
+		DataPlaneUrl:   server.URL,
+		Logger:         testLogger{t.Logf, t.Logf},
+		NoProxySupport: true,
+		Timeout:        10 * time.Millisecond,
+	})
+
+	c := &client{
+		Config: config,
+		http:   makeHttpClient(config.Transport, config.Timeout),
+	}
+
+	if err := c.upload([]byte(`{"batch":[]}`), "0"); err == nil {
+		t.Error("the request should have timed out")
+	}
+}
+
+func TestTransportConfigError(t *testing.T) {
+	tests := map[string]Config{
+		"Timeout":      {Timeout: -1},
+		"MaxIdleConns": {MaxIdleConns: -1},
+		"Proxy":        {Transport: testTransportOK, Proxy: http.ProxyFromEnvironment},
+		"TLSConfig":    {Transport: testTransportOK, TLSConfig: &tls.Config{}},
+	}
+
+	for field, config := range tests {
+		t.Run(field, func(t *testing.T) {
+			_, err := NewWithConfig(WRITE_KEY, config)
+
+			if e, ok := err.(ConfigError); !ok || e.Field != field {
+				t.Error("invalid configuration error:", err)
+			}
+		})
+	}
+}
+
+func TestTransportClosedWithClient(t *testing.T) {
+	closed := make(chan struct{}, 1)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			select {
+			case closed <- struct{}{}:
+			default:
+			}
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	client, _ := NewWithConfig(WRITE_KEY, Config{
+		DataPlaneUrl:   server.URL,
+		Logger:         testLogger{t.Logf, t.Logf},
+		NoProxySupport: true,
+	})
+
+	client.Enqueue(Track{UserId: "A", Event: "B"})
+	client.Close()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Error("the idle connections of the client should have been closed")
+	}
+}
+
+func TestTransportOwned(t *testing.T) {
+	if ownedTransport(Config{Transport: testTransportOK}, makeConfig(Config{Transport: testTransportOK})) != nil {
+		t.Error("the transport of the application should not be owned by the client")
+	}
+
+	if ownedTransport(Config{}, makeConfig(Config{})) == nil {
+		t.Error("the transport created for the client should be owned by the client")
+	}
+}
@@ -0,0 +1,274 @@
+package analytics
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// This type represents the user agent client hints sent by browsers in the
+// `Sec-CH-UA-*` headers, it mirrors the `userAgentData` object reported by the
+// JavaScript SDK and is stored under that key in the extra fields of contexts
+// built by `ContextFromRequest`.
+type UserAgentData struct {
+	Brands          []UserAgentBrand `json:"brands,omitempty"`
+	Mobile          bool             `json:"mobile"`
+	Platform        string           `json:"platform,omitempty"`
+	PlatformVersion string           `json:"platformVersion,omitempty"`
+	Model           string           `json:"model,omitempty"`
+}
+
+// This type represents a brand listed in the `Sec-CH-UA` header.
+type UserAgentBrand struct {
+	Brand   string `json:"brand"`
+	Version string `json:"version"`
+}
+
+// Parses the client hints headers of a request, the function returns nil if
+// the browser didn't send any.
+func ParseClientHints(h http.Header) *UserAgentData {
+	brands := h.Get("Sec-CH-UA-Full-Version-List")
+	if len(brands) == 0 {
+		brands = h.Get("Sec-CH-UA")
+	}
+
+	if len(brands) == 0 && len(h.Get("Sec-CH-UA-Platform")) == 0 {
+		return nil
+	}
+
+	data := &UserAgentData{
+		Mobile:          h.Get("Sec-CH-UA-Mobile") == "?1",
+		Platform:        unquoteHint(h.Get("Sec-CH-UA-Platform")),
+		PlatformVersion: unquoteHint(h.Get("Sec-CH-UA-Platform-Version")),
+		Model:           unquoteHint(h.Get("Sec-CH-UA-Model")),
+	}
+
+	// The header is a structured list like:
+	// "Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"
+	for _, item := range strings.Split(brands, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		brand := UserAgentBrand{Brand: unquoteHint(name)}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "v="); ok {
+			brand.Version = unquoteHint(v)
+		}
+		if len(brand.Brand) != 0 {
+			data.Brands = append(data.Brands, brand)
+		}
+	}
+
+	return data
+}
+
+func unquoteHint(s string) string {
+	return strings.Trim(strings.TrimSpace(s), `"`)
+}
+
+// This enricher parses the `UserAgent` field of contexts, and the client hints
+// stored by `ContextFromRequest` when available, to fill the `OS` and `Device`
+// fields. The browser name and version are set under the "browser" key of the
+// extra fields.
+//
+// The parsing is done locally with a small set of rules covering the common
+// browsers and platforms, it doesn't aim at recognizing every user agent.
+type UserAgentEnricher struct{}
+
+func (UserAgentEnricher) Enrich(ctx *Context) {
+	if len(ctx.UserAgent) == 0 {
+		return
+	}
+
+	ua := parseUserAgent(ctx.UserAgent)
+
+	switch hints := ctx.Extra["userAgentData"].(type) {
+	case *UserAgentData:
+		ua.applyHints(hints)
+	case UserAgentData:
+		ua.applyHints(&hints)
+	}
+
+	setDefault(&ctx.OS.Name, ua.osName)
+	setDefault(&ctx.OS.Version, ua.osVersion)
+	setDefault(&ctx.Device.Type, ua.deviceType)
+	setDefault(&ctx.Device.Model, ua.deviceModel)
+	setDefault(&ctx.Device.Manufacturer, ua.deviceManufacturer)
+
+	if len(ua.browserName) != 0 {
+		if _, ok := ctx.Extra["browser"]; !ok {
+			if ctx.Extra == nil {
+				ctx.Extra = make(map[string]interface{})
+			}
+			ctx.Extra["browser"] = map[string]interface{}{
+				"name":    ua.browserName,
+				"version": ua.browserVersion,
+			}
+		}
+	}
+}
+
+func setDefault(field *string, value string) {
+	if len(*field) == 0 {
+		*field = value
+	}
+}
+
+type userAgent struct {
+	browserName        string
+	browserVersion     string
+	osName             string
+	osVersion          string
+	deviceType         string
+	deviceModel        string
+	deviceManufacturer string
+}
+
+var (
+	uaBrowsers = []struct {
+		name string
+		re   *regexp.Regexp
+	}{
+		// The order matters, most browsers also advertise the engines they
+		// are based on.
+		{"Edge", regexp.MustCompile(`(?:Edg|EdgA|EdgiOS|Edge)/([\d.]+)`)},
+		{"Opera", regexp.MustCompile(`(?:OPR|Opera)/([\d.]+)`)},
+		{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/([\d.]+)`)},
+		{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/([\d.]+)`)},
+		{"Chromium", regexp.MustCompile(`Chromium/([\d.]+)`)},
+		{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/([\d.]+)`)},
+		{"Safari", regexp.MustCompile(`Version/([\d.]+).*Safari/`)},
+		{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)([\d.]+)`)},
+	}
+
+	uaWindows = regexp.MustCompile(`Windows NT ([\d.]+)`)
+	uaIOS     = regexp.MustCompile(`(?:iPhone|CPU) OS ([\d_]+)`)
+	uaAndroid = regexp.MustCompile(`Android ([\d.]+)(?:; ([^;)]+))?`)
+	uaMacOS   = regexp.MustCompile(`Mac OS X ([\d_.]+)`)
+	uaBot     = regexp.MustCompile(`(?i)bot|crawler|spider|slurp`)
+
+	windowsVersions = map[string]string{
+		"10.0": "10",
+		"6.3":  "8.1",
+		"6.2":  "8",
+		"6.1":  "7",
+		"6.0":  "Vista",
+		"5.2":  "XP",
+		"5.1":  "XP",
+	}
+)
+
+func parseUserAgent(s string) (ua userAgent) {
+	for _, b := range uaBrowsers {
+		if m := b.re.FindStringSubmatch(s); m != nil {
+			ua.browserName, ua.browserVersion = b.name, m[1]
+			break
+		}
+	}
+
+	switch {
+	case strings.Contains(s, "Windows Phone"):
+		ua.osName, ua.deviceType = "Windows Phone", "mobile"
+
+	case strings.Contains(s, "iPhone"), strings.Contains(s, "iPod"):
+		ua.osName, ua.deviceType, ua.deviceManufacturer = "iOS", "mobile", "Apple"
+		ua.deviceModel = "iPhone"
+		if strings.Contains(s, "iPod") {
+			ua.deviceModel = "iPod"
+		}
+		if m := uaIOS.FindStringSubmatch(s); m != nil {
+			ua.osVersion = strings.ReplaceAll(m[1], "_", ".")
+		}
+
+	case strings.Contains(s, "iPad"):
+		ua.osName, ua.deviceType, ua.deviceManufacturer = "iOS", "tablet", "Apple"
+		ua.deviceModel = "iPad"
+		if m := uaIOS.FindStringSubmatch(s); m != nil {
+			ua.osVersion = strings.ReplaceAll(m[1], "_", ".")
+		}
+
+	case strings.Contains(s, "Android"):
+		ua.osName, ua.deviceType = "Android", "tablet"
+		if strings.Contains(s, "Mobile") {
+			ua.deviceType = "mobile"
+		}
+		if m := uaAndroid.FindStringSubmatch(s); m != nil {
+			ua.osVersion = m[1]
+			model, _, _ := strings.Cut(strings.TrimSpace(m[2]), " Build/")
+			// Browsers with a reduced user agent replace the model with "K".
+			if model != "K" {
+				ua.deviceModel = model
+				ua.deviceManufacturer = manufacturerOf(model)
+			}
+		}
+
+	case strings.Contains(s, "Windows"):
+		ua.osName, ua.deviceType = "Windows", "desktop"
+		if m := uaWindows.FindStringSubmatch(s); m != nil {
+			ua.osVersion = windowsVersions[m[1]]
+		}
+
+	case strings.Contains(s, "Macintosh"):
+		ua.osName, ua.deviceType, ua.deviceManufacturer = "macOS", "desktop", "Apple"
+		if m := uaMacOS.FindStringSubmatch(s); m != nil {
+			ua.osVersion = strings.ReplaceAll(m[1], "_", ".")
+		}
+
+	case strings.Contains(s, "CrOS"):
+		ua.osName, ua.deviceType = "Chrome OS", "desktop"
+
+	case strings.Contains(s, "Linux"):
+		ua.osName, ua.deviceType = "Linux", "desktop"
+	}
+
+	if uaBot.MatchString(s) {
+		ua.deviceType = "bot"
+	}
+
+	return
+}
+
+// Client hints are more accurate than the user agent string, which browsers
+// are freezing, so they take precedence when available.
+func (ua *userAgent) applyHints(hints *UserAgentData) {
+	if len(hints.Platform) != 0 {
+		ua.osName = hints.Platform
+		ua.osVersion = hints.PlatformVersion
+	}
+
+	if len(hints.Model) != 0 {
+		ua.deviceModel = hints.Model
+		ua.deviceManufacturer = manufacturerOf(hints.Model)
+	}
+
+	if hints.Mobile {
+		ua.deviceType = "mobile"
+	}
+
+	// Brands contain "Chromium" along with the actual browser and a fake
+	// brand meant to prevent sniffing, pick the most specific one.
+	var chromium *UserAgentBrand
+	for i, b := range hints.Brands {
+		switch {
+		case strings.Contains(b.Brand, "Brand"):
+		case b.Brand == "Chromium":
+			chromium = &hints.Brands[i]
+		default:
+			ua.browserName = strings.TrimPrefix(strings.TrimPrefix(b.Brand, "Google "), "Microsoft ")
+			ua.browserVersion = b.Version
+			return
+		}
+	}
+	if chromium != nil {
+		ua.browserName, ua.browserVersion = chromium.Brand, chromium.Version
+	}
+}
+
+func manufacturerOf(model string) string {
+	switch {
+	case strings.HasPrefix(model, "SM-"), strings.HasPrefix(model, "GT-"):
+		return "Samsung"
+	case strings.HasPrefix(model, "Pixel"), strings.HasPrefix(model, "Nexus"):
+		return "Google"
+	case strings.HasPrefix(model, "iPhone"), strings.HasPrefix(model, "iPad"):
+		return "Apple"
+	}
+	return ""
+}
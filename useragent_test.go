@@ -0,0 +1,118 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUserAgentEnricher(t *testing.T) {
+	tests := map[string]struct {
+		ua      string
+		os      OSInfo
+		device  DeviceInfo
+		browser map[string]interface{}
+	}{
+		"chrome windows": {
+			ua:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+			os:      OSInfo{Name: "Windows", Version: "10"},
+			device:  DeviceInfo{Type: "desktop"},
+			browser: map[string]interface{}{"name": "Chrome", "version": "118.0.0.0"},
+		},
+		"edge windows": {
+			ua:      "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46",
+			os:      OSInfo{Name: "Windows", Version: "10"},
+			device:  DeviceInfo{Type: "desktop"},
+			browser: map[string]interface{}{"name": "Edge", "version": "118.0.2088.46"},
+		},
+		"safari iphone": {
+			ua:      "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+			os:      OSInfo{Name: "iOS", Version: "17.1"},
+			device:  DeviceInfo{Type: "mobile", Model: "iPhone", Manufacturer: "Apple"},
+			browser: map[string]interface{}{"name": "Safari", "version": "17.1"},
+		},
+		"firefox mac": {
+			ua:      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:109.0) Gecko/20100101 Firefox/119.0",
+			os:      OSInfo{Name: "macOS", Version: "10.15"},
+			device:  DeviceInfo{Type: "desktop", Manufacturer: "Apple"},
+			browser: map[string]interface{}{"name": "Firefox", "version": "119.0"},
+		},
+		"samsung android": {
+			ua:      "Mozilla/5.0 (Linux; Android 13; SM-S901B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+			os:      OSInfo{Name: "Android", Version: "13"},
+			device:  DeviceInfo{Type: "mobile", Model: "SM-S901B", Manufacturer: "Samsung"},
+			browser: map[string]interface{}{"name": "Samsung Internet", "version": "23.0"},
+		},
+		"bot": {
+			ua:      "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+			device:  DeviceInfo{Type: "bot"},
+			browser: nil,
+		},
+	}
+
+	for name, test := range tests {
+		ctx := &Context{UserAgent: test.ua}
+		UserAgentEnricher{}.Enrich(ctx)
+
+		if ctx.OS != test.os {
+			t.Errorf("%s: invalid os: %#v", name, ctx.OS)
+		}
+
+		if ctx.Device != test.device {
+			t.Errorf("%s: invalid device: %#v", name, ctx.Device)
+		}
+
+		if browser, _ := ctx.Extra["browser"].(map[string]interface{}); !reflect.DeepEqual(browser, test.browser) {
+			t.Errorf("%s: invalid browser: %#v", name, ctx.Extra["browser"])
+		}
+	}
+}
+
+func TestUserAgentEnricherKeepsApplicationFields(t *testing.T) {
+	ctx := &Context{
+		UserAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+		OS:        OSInfo{Name: "Windows Server"},
+		Extra:     map[string]interface{}{"browser": "custom"},
+	}
+	UserAgentEnricher{}.Enrich(ctx)
+
+	if ctx.OS.Name != "Windows Server" {
+		t.Error("the enricher overwrote the os name:", ctx.OS.Name)
+	}
+
+	if ctx.Extra["browser"] != "custom" {
+		t.Error("the enricher overwrote the browser:", ctx.Extra["browser"])
+	}
+}
+
+func TestUserAgentEnricherClientHints(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://example.com/", nil)
+	r.Header.Set("User-Agent", "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36")
+	r.Header.Set("Sec-CH-UA", `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`)
+	r.Header.Set("Sec-CH-UA-Mobile", "?1")
+	r.Header.Set("Sec-CH-UA-Platform", `"Android"`)
+	r.Header.Set("Sec-CH-UA-Platform-Version", `"14.0.0"`)
+	r.Header.Set("Sec-CH-UA-Model", `"Pixel 7"`)
+
+	ctx := ContextFromRequest(r, RequestContextOptions{})
+	UserAgentEnricher{}.Enrich(ctx)
+
+	if ref := (OSInfo{Name: "Android", Version: "14.0.0"}); ctx.OS != ref {
+		t.Errorf("invalid os: %#v", ctx.OS)
+	}
+
+	if ref := (DeviceInfo{Type: "mobile", Model: "Pixel 7", Manufacturer: "Google"}); ctx.Device != ref {
+		t.Errorf("invalid device: %#v", ctx.Device)
+	}
+
+	if ref := (map[string]interface{}{"name": "Chrome", "version": "118"}); !reflect.DeepEqual(ctx.Extra["browser"], ref) {
+		t.Errorf("invalid browser: %#v", ctx.Extra["browser"])
+	}
+}
+
+func TestParseClientHintsMissing(t *testing.T) {
+	if hints := ParseClientHints(http.Header{}); hints != nil {
+		t.Errorf("invalid client hints parsed from empty headers: %#v", hints)
+	}
+}
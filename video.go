@@ -0,0 +1,166 @@
+package analytics
+
+// Names of the events defined by the Video spec.
+const (
+	EventVideoPlaybackStarted         = "Video Playback Started"
+	EventVideoPlaybackPaused          = "Video Playback Paused"
+	EventVideoPlaybackInterrupted     = "Video Playback Interrupted"
+	EventVideoPlaybackBufferStarted   = "Video Playback Buffer Started"
+	EventVideoPlaybackBufferCompleted = "Video Playback Buffer Completed"
+	EventVideoPlaybackSeekStarted     = "Video Playback Seek Started"
+	EventVideoPlaybackSeekCompleted   = "Video Playback Seek Completed"
+	EventVideoPlaybackResumed         = "Video Playback Resumed"
+	EventVideoPlaybackCompleted       = "Video Playback Completed"
+	EventVideoPlaybackExited          = "Video Playback Exited"
+
+	EventVideoContentStarted   = "Video Content Started"
+	EventVideoContentPlaying   = "Video Content Playing"
+	EventVideoContentCompleted = "Video Content Completed"
+)
+
+// This type represents the properties of the playback events of the Video
+// spec, positions and lengths are expressed in seconds. The position, sound and
+// boolean properties are always reported since their zero values are
+// meaningful, a playback at position 0 or with the sound muted for example.
+type VideoPlayback struct {
+	SessionId       string   `json:"session_id,omitempty"`
+	ContentAssetIds []string `json:"content_asset_ids,omitempty"`
+	ContentPodIds   []string `json:"content_pod_ids,omitempty"`
+	AdAssetId       string   `json:"ad_asset_id,omitempty"`
+	AdPodId         string   `json:"ad_pod_id,omitempty"`
+	AdType          string   `json:"ad_type,omitempty"`
+	Position        int      `json:"position"`
+	TotalLength     int      `json:"total_length,omitempty"`
+	Bitrate         int      `json:"bitrate,omitempty"`
+	Framerate       float64  `json:"framerate,omitempty"`
+	VideoPlayer     string   `json:"video_player,omitempty"`
+	Sound           int      `json:"sound"`
+	FullScreen      bool     `json:"full_screen"`
+	AdEnabled       bool     `json:"ad_enabled"`
+	Quality         string   `json:"quality,omitempty"`
+	Livestream      bool     `json:"livestream"`
+}
+
+func (v VideoPlayback) Validate() error {
+	if len(v.SessionId) == 0 {
+		return FieldError{
+			Type:  "analytics.VideoPlayback",
+			Name:  "SessionId",
+			Value: v.SessionId,
+		}
+	}
+
+	return nil
+}
+
+// This type represents the properties of the content events of the Video
+// spec, positions and lengths are expressed in seconds. The position and
+// boolean properties are always reported since their zero values are
+// meaningful.
+type VideoContent struct {
+	SessionId   string   `json:"session_id,omitempty"`
+	AssetId     string   `json:"asset_id,omitempty"`
+	PodId       string   `json:"pod_id,omitempty"`
+	Program     string   `json:"program,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Season      string   `json:"season,omitempty"`
+	Episode     string   `json:"episode,omitempty"`
+	Genre       string   `json:"genre,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Channel     string   `json:"channel,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Airdate     string   `json:"airdate,omitempty"`
+	Position    int      `json:"position"`
+	TotalLength int      `json:"total_length,omitempty"`
+	Bitrate     int      `json:"bitrate,omitempty"`
+	Framerate   float64  `json:"framerate,omitempty"`
+	FullEpisode bool     `json:"full_episode"`
+	Livestream  bool     `json:"livestream"`
+}
+
+func (v VideoContent) Validate() error {
+	if len(v.SessionId) == 0 {
+		return FieldError{
+			Type:  "analytics.VideoContent",
+			Name:  "SessionId",
+			Value: v.SessionId,
+		}
+	}
+
+	if len(v.AssetId) == 0 {
+		return FieldError{
+			Type:  "analytics.VideoContent",
+			Name:  "AssetId",
+			Value: v.AssetId,
+		}
+	}
+
+	return nil
+}
+
+func NewVideoPlaybackStarted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackStarted, v)
+}
+
+func NewVideoPlaybackPaused(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackPaused, v)
+}
+
+func NewVideoPlaybackInterrupted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackInterrupted, v)
+}
+
+func NewVideoPlaybackBufferStarted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackBufferStarted, v)
+}
+
+func NewVideoPlaybackBufferCompleted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackBufferCompleted, v)
+}
+
+func NewVideoPlaybackSeekStarted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackSeekStarted, v)
+}
+
+func NewVideoPlaybackSeekCompleted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackSeekCompleted, v)
+}
+
+func NewVideoPlaybackResumed(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackResumed, v)
+}
+
+func NewVideoPlaybackCompleted(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackCompleted, v)
+}
+
+func NewVideoPlaybackExited(v VideoPlayback) (Track, error) {
+	return videoPlaybackTrack(EventVideoPlaybackExited, v)
+}
+
+func NewVideoContentStarted(v VideoContent) (Track, error) {
+	return videoContentTrack(EventVideoContentStarted, v)
+}
+
+func NewVideoContentPlaying(v VideoContent) (Track, error) {
+	return videoContentTrack(EventVideoContentPlaying, v)
+}
+
+func NewVideoContentCompleted(v VideoContent) (Track, error) {
+	return videoContentTrack(EventVideoContentCompleted, v)
+}
+
+func videoPlaybackTrack(event string, v VideoPlayback) (Track, error) {
+	if err := v.Validate(); err != nil {
+		return Track{}, err
+	}
+	return specTrack(event, specProperties(v)), nil
+}
+
+func videoContentTrack(event string, v VideoContent) (Track, error) {
+	if err := v.Validate(); err != nil {
+		return Track{}, err
+	}
+	return specTrack(event, specProperties(v)), nil
+}
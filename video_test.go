@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVideoPlaybackEvents(t *testing.T) {
+	playback := VideoPlayback{
+		SessionId:       "12345",
+		ContentAssetIds: []string{"0129370"},
+		Position:        30,
+		TotalLength:     392,
+		VideoPlayer:     "youtube",
+		FullScreen:      true,
+	}
+
+	ref := Properties{
+		"session_id":        "12345",
+		"content_asset_ids": []string{"0129370"},
+		"position":          30,
+		"total_length":      392,
+		"video_player":      "youtube",
+		"sound":             0,
+		"full_screen":       true,
+		"ad_enabled":        false,
+		"livestream":        false,
+	}
+
+	tests := map[string]func(VideoPlayback) (Track, error){
+		EventVideoPlaybackStarted:   NewVideoPlaybackStarted,
+		EventVideoPlaybackPaused:    NewVideoPlaybackPaused,
+		EventVideoPlaybackCompleted: NewVideoPlaybackCompleted,
+	}
+
+	for event, build := range tests {
+		track, err := build(playback)
+		if err != nil {
+			t.Errorf("%s: building the event failed: %s", event, err)
+			continue
+		}
+
+		if track.Event != event {
+			t.Errorf("%s: invalid event name: %s", event, track.Event)
+		}
+
+		if !reflect.DeepEqual(track.Properties, ref) {
+			t.Errorf("%s: invalid properties produced: %#v", event, track.Properties)
+		}
+
+		if _, err := build(VideoPlayback{}); err != (FieldError{
+			Type:  "analytics.VideoPlayback",
+			Name:  "SessionId",
+			Value: "",
+		}) {
+			t.Errorf("%s: invalid error returned for a missing session id: %v", event, err)
+		}
+	}
+}
+
+func TestVideoContentPlaying(t *testing.T) {
+	track, err := NewVideoContentPlaying(VideoContent{
+		SessionId: "12345",
+		AssetId:   "0129370",
+		Title:     "Interview with Tony Robbins",
+		Position:  163,
+	})
+	if err != nil {
+		t.Fatal("building the event failed:", err)
+	}
+
+	if track.Event != EventVideoContentPlaying {
+		t.Error("invalid event name:", track.Event)
+	}
+
+	ref := Properties{
+		"session_id":   "12345",
+		"asset_id":     "0129370",
+		"title":        "Interview with Tony Robbins",
+		"position":     163,
+		"full_episode": false,
+		"livestream":   false,
+	}
+
+	if !reflect.DeepEqual(track.Properties, ref) {
+		t.Errorf("invalid properties produced: %#v", track.Properties)
+	}
+
+	track, _ = NewVideoContentStarted(VideoContent{SessionId: "12345", AssetId: "0129370"})
+	if position, ok := track.Properties["position"]; !ok || position != 0 {
+		t.Error("the position should be reported at the start of the content:", position)
+	}
+
+	if _, err := NewVideoContentPlaying(VideoContent{SessionId: "12345"}); err != (FieldError{
+		Type:  "analytics.VideoContent",
+		Name:  "AssetId",
+		Value: "",
+	}) {
+		t.Error("invalid error returned for a missing asset id:", err)
+	}
+}